@@ -5,57 +5,330 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/MyLi2tlePony/sql-migrator/internal/app"
+	"github.com/MyLi2tlePony/sql-migrator/internal/config"
 	"github.com/MyLi2tlePony/sql-migrator/internal/logger"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	_ "github.com/MyLi2tlePony/sql-migrator/pkg/storage/postgres"
+	_ "github.com/MyLi2tlePony/sql-migrator/pkg/storage/sqlite"
+)
+
+type command struct {
+	name        string
+	description string
+	flags       *flag.FlagSet
+
+	// args — имена позиционных аргументов, values — их значения после разбора.
+	args   []string
+	values []string
+}
+
+// varsFlag разбирает повторяемый флаг --var key=value в переменные шаблонов миграций.
+type varsFlag struct{}
+
+const (
+	exitFailure = 1 + iota
+	exitUsage
+	exitConnection
+	exitMigration
+	exitLockTimeout
+	exitChecksumMismatch
 )
 
 var (
 	ErrInvalidFlagNumber = errors.New("invalid flag number")
+	ErrUnknownCommand    = errors.New("unknown command")
+	ErrInvalidVar        = errors.New("expected key=value")
+
+	configPath      string
+	migrationName   string
+	flagConfig      config.Config
+	targetVersion   int64
+	historyVersion  int64
+	repair          bool
+	forceStatus     string
+	baselineVersion int64
 
-	path          string
-	database      string
-	migrationName string
+	commands []*command
 )
 
 func init() {
-	flag.StringVar(&path, "path", "", "Path to migrations file")
-	flag.StringVar(&database, "database", "", "Database connection string")
-	flag.StringVar(&migrationName, "name", "", "Migration name")
+	flag.Usage = usage
+	registerCommonFlags(flag.CommandLine)
+
+	newCommand("create", "Create a new migration template")
+
+	up := newCommand("up", "Apply all pending migrations")
+	up.Int64Var(&targetVersion, "to", 0, "Apply migrations up to and including this version")
+	up.BoolVar(&flagConfig.AllowOutOfOrder, "allow-out-of-order", false,
+		"Apply pending migrations with versions below the last applied one")
+	registerDryRunFlag(up)
+
+	down := newCommand("down", "Roll back the last applied migration")
+	down.Int64Var(&targetVersion, "to", 0, "Roll back migrations above this version")
+	registerDryRunFlag(down)
+
+	registerDryRunFlag(newCommand("redo", "Roll back and re-apply the last applied migration"))
+	newCommand("status", "Print migrations status")
+	newCommand("dbversion", "Print the version of the last applied migration")
+	newCommand("validate", "Compare checksums of applied migrations with the files on disk").
+		BoolVar(&repair, "repair", false, "Store checksums of the migration files on disk")
+	newCommand("history", "Print the log of migration status changes").
+		Int64Var(&historyVersion, "version", 0, "Show history of this migration version only")
+	newCommand("force", "Set the status of a migration without running it", "version").
+		StringVar(&forceStatus, "status", migration.ForceApplied, "New status: applied or rolled-back")
+	newCommand("baseline", "Mark migrations up to a version as present in an existing schema without running them").
+		Int64Var(&baselineVersion, "version", 0, "Last migration version already present in the schema")
+	newCommand("convert", "Switch sequential versions of migration files and the database to timestamps")
+}
+
+// registerCommonFlags регистрирует общие флаги, чтобы их можно было указывать как до, так и после команды.
+func registerCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configPath, "config", "", "Path to YAML or JSON config file")
+	fs.StringVar(&flagConfig.Dir, "path", "", "Path to migrations file")
+	fs.StringVar(&flagConfig.DSN, "database", "", "Database connection string")
+	fs.StringVar(&migrationName, "name", "", "Migration name")
+	fs.StringVar(&flagConfig.Type, "type", "", "Migration type: sql or go")
+	fs.StringVar(&flagConfig.Schema, "schema", "", "Schema of the migrations table, created if missing")
+	fs.StringVar(&flagConfig.Table, "table", "", "Migrations table name")
+	fs.BoolVar(&flagConfig.SingleFile, "single-file", false, "Create migration as one file with -- +up/-- +down sections")
+	fs.StringVar(&flagConfig.Versioning, "versioning", "", "Version scheme for new migrations: sequential or timestamp")
+	fs.StringVar(&flagConfig.Format, "format", "", "Output format: table, json, yaml or csv")
+	fs.DurationVar(&flagConfig.LockTimeout, "lock-timeout", 0, "Migration lock wait timeout")
+	fs.DurationVar(&flagConfig.StatementTimeout, "statement-timeout", 0, "statement_timeout set before each migration")
+	fs.DurationVar(&flagConfig.DBLockTimeout, "db-lock-timeout", 0, "lock_timeout set before each migration")
+	fs.IntVar(&flagConfig.LockRetries, "lock-retries", 0, "Retries of a migration that failed on lock_timeout")
+	fs.Var(varsFlag{}, "var", "Variable key=value for migrations marked -- +migrate Template, can be repeated")
+	fs.DurationVar(&flagConfig.LockRetryBackoff, "lock-retry-backoff", 0, "Delay before the first lock retry, doubled each time")
+}
+
+func (varsFlag) String() string {
+	return ""
+}
+
+func (varsFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidVar, value)
+	}
+
+	flagConfig.SetVar(key, val)
+	return nil
+}
+
+func registerDryRunFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&flagConfig.DryRun, "dry-run", false, "Print migrations that would be executed without applying them")
+}
+
+func newCommand(name, description string, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	registerCommonFlags(fs)
+
+	commands = append(commands, &command{
+		name:        name,
+		description: description,
+		flags:       fs,
+		args:        args,
+	})
+
+	return fs
+}
+
+// parseArgs разбирает флаги команды, которые могут стоять как до, так и после позиционных аргументов.
+func (cmd *command) parseArgs(args []string) {
+	for {
+		_ = cmd.flags.Parse(args)
+		if cmd.flags.NArg() == 0 {
+			return
+		}
+
+		cmd.values = append(cmd.values, cmd.flags.Arg(0))
+		args = cmd.flags.Args()[1:]
+	}
+}
+
+func (cmd *command) usageName() string {
+	name := cmd.name
+	for _, arg := range cmd.args {
+		name += " <" + arg + ">"
+	}
+
+	return name
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintln(out, "Usage: gomigrator [flags] <command> [command flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-18s %s\n", cmd.usageName(), cmd.description)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
 }
 
 func main() {
 	flag.Parse()
-	if len(flag.Args()) > 1 {
-		fmt.Println(ErrInvalidFlagNumber)
-		return
+
+	cmd := findCommand(flag.Arg(0))
+	if cmd == nil {
+		if flag.Arg(0) != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n\n", ErrUnknownCommand, flag.Arg(0))
+		}
+
+		usage()
+		os.Exit(exitUsage)
 	}
 
-	if path == "" {
-		path = os.Getenv("path")
+	cmd.parseArgs(flag.Args()[1:])
+	if len(cmd.values) != len(cmd.args) {
+		fmt.Fprintln(os.Stderr, ErrInvalidFlagNumber)
+		usage()
+		os.Exit(exitUsage)
 	}
-	if database == "" {
-		database = os.Getenv("database")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
+
 	if migrationName == "" {
 		migrationName = os.Getenv("name")
 	}
 
 	l := logger.New()
-	application := app.New(l)
+	application := app.New(l, cfg)
+
+	if err = run(application, cmd); err != nil {
+		// Ошибки мигратора уже залогированы им самим.
+		var migrationErr *migration.Error
+		if !errors.As(err, &migrationErr) {
+			l.Error(err.Error())
+		}
 
-	switch flag.Arg(0) {
+		os.Exit(exitCode(err))
+	}
+}
+
+func run(application app.App, cmd *command) error {
+	switch cmd.name {
 	case "create":
-		application.Create(migrationName, path)
+		return application.Create(migrationName)
 	case "up":
-		application.Up(path, database)
+		if isFlagSet(cmd.flags, "to") {
+			return application.UpTo(targetVersion)
+		}
+
+		return application.Up()
 	case "down":
-		application.Down(path, database)
+		if isFlagSet(cmd.flags, "to") {
+			return application.DownTo(targetVersion)
+		}
+
+		return application.Down()
 	case "redo":
-		application.Redo(path, database)
+		return application.Redo()
 	case "status":
-		application.Status(database)
+		return application.Status()
 	case "dbversion":
-		application.DbVersion(database)
+		return application.DbVersion()
+	case "validate":
+		if repair {
+			return application.Repair()
+		}
+
+		return application.Validate()
+	case "history":
+		return application.History(historyVersion)
+	case "force":
+		version, err := strconv.ParseInt(cmd.values[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: version %q", app.ErrInvalidArguments, cmd.values[0])
+		}
+
+		return application.Force(version, forceStatus)
+	case "baseline":
+		return application.Baseline(baselineVersion)
+	case "convert":
+		return application.Convert()
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, cmd.name)
+	}
+}
+
+func exitCode(err error) int {
+	var migrationErr *migration.Error
+
+	switch {
+	case errors.Is(err, app.ErrInvalidArguments), errors.Is(err, ErrUnknownCommand), errors.Is(err, storage.ErrUnknownDriver),
+		errors.Is(err, migration.ErrUnknownForceStatus):
+		return exitUsage
+	case errors.Is(err, migration.ErrLockTimeout):
+		return exitLockTimeout
+	case errors.Is(err, migration.ErrChecksumMismatch):
+		return exitChecksumMismatch
+	case errors.Is(err, migration.ErrConnect):
+		return exitConnection
+	case errors.As(err, &migrationErr):
+		return exitMigration
+	default:
+		return exitFailure
+	}
+}
+
+// loadConfig собирает конфигурацию с приоритетом: флаги, затем файл конфигурации, затем переменные окружения.
+func loadConfig() (config.Config, error) {
+	envConfig, err := config.FromEnv()
+	if err != nil {
+		return config.Config{}, err
 	}
+
+	cfg := config.Default().Merge(envConfig)
+
+	if configPath == "" {
+		configPath = os.Getenv("config")
+	}
+
+	if configPath != "" {
+		fileConfig, err := config.Load(configPath)
+		if err != nil {
+			return config.Config{}, err
+		}
+
+		cfg = cfg.Merge(fileConfig)
+	}
+
+	cfg = cfg.Merge(flagConfig)
+
+	return cfg, cfg.Validate()
 }
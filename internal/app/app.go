@@ -4,33 +4,54 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/MyLi2tlePony/sql-migrator/internal/config"
 	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
 )
 
 type App interface {
-	Create(name, path string)
-	Up(path, connString string)
-	Down(path, connString string)
-	Redo(path, connString string)
-	Status(connString string)
-	DbVersion(connString string)
+	Create(name string) error
+	Up() error
+	UpTo(version int64) error
+	Down() error
+	DownTo(version int64) error
+	Redo() error
+	Status() error
+	DbVersion() error
+	Validate() error
+	Repair() error
+	History(version int64) error
+	Force(version int64, status string) error
+	Baseline(version int64) error
+	Convert() error
 }
 
 type Migration interface {
 	Connect(context.Context) error
 	Close(context.Context) error
-	Create(name, up, down string)
-	Up(context.Context) error
-	Down(context.Context) error
-	Redo(context.Context) error
-	Status(context.Context) error
-	DbVersion(context.Context) error
+	Create(version int64, name, up, down string)
+	Add(migration.SQLMigration)
+	Up(context.Context) ([]migration.StatusRecord, error)
+	UpTo(context.Context, int64) ([]migration.StatusRecord, error)
+	Down(context.Context) ([]migration.StatusRecord, error)
+	DownTo(context.Context, int64) ([]migration.StatusRecord, error)
+	Redo(context.Context) ([]migration.StatusRecord, error)
+	Status(context.Context) ([]migration.StatusRecord, error)
+	DbVersion(context.Context) (int64, error)
+	Validate(context.Context) ([]migration.StatusRecord, error)
+	Repair(context.Context) ([]migration.StatusRecord, error)
+	Plan(context.Context, migration.Action, int64) ([]migration.PlanStep, error)
+	History(context.Context, int64) ([]entity.History, error)
+	Force(ctx context.Context, version int64, status string) ([]migration.StatusRecord, error)
+	Baseline(ctx context.Context, version int64) ([]migration.StatusRecord, error)
+	ConvertVersions(context.Context) ([]migration.StatusRecord, error)
 }
 
 type Logger interface {
@@ -40,47 +61,80 @@ type Logger interface {
 
 type application struct {
 	logger Logger
+	config config.Config
+	out    io.Writer
 }
 
-type localMigration struct {
-	Version int
-	Name    string
+var (
+	ErrInvalidArguments = errors.New("invalid arguments")
 
-	Up   string
-	Down string
-}
+	regGetVersion = regexp.MustCompile(`^\d+`)
 
-var (
-	ErrInvalidMigrationName = errors.New("invalid migration name")
+	singleFileTemplate = []byte("-- +up\n\n-- +down\n")
+)
 
-	regGetVersion       = regexp.MustCompile(`^\d+`)
-	regGetUpMigration   = regexp.MustCompile(`^.+_up\.sql$`)
-	regGetDownMigration = regexp.MustCompile(`^.+_down\.sql$`)
+const goTemplate = `package migrations
+
+import (
+	"context"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
 )
 
-func New(logger Logger) App {
+func init() {
+	migration.Register(%[1]d, %[2]q, up%[3]s, down%[3]s)
+}
+
+func up%[3]s(ctx context.Context, tx entity.Executor) error {
+	return nil
+}
+
+func down%[3]s(ctx context.Context, tx entity.Executor) error {
+	return nil
+}
+`
+
+func New(logger Logger, config config.Config) App {
 	return &application{
 		logger: logger,
+		config: config,
+		out:    os.Stdout,
 	}
 }
 
-func (app *application) Create(name, filePath string) {
+func (app *application) newMigrator() (migration.Migration, error) {
+	return migration.New(app.config.DSN, app.logger,
+		migration.WithLockTimeout(app.config.LockTimeout),
+		migration.WithSchema(app.config.Schema),
+		migration.WithTable(app.config.Table),
+		migration.WithAllowOutOfOrder(app.config.AllowOutOfOrder),
+		migration.WithTimeouts(app.config.StatementTimeout, app.config.DBLockTimeout),
+		migration.WithLockRetries(app.config.LockRetries, app.config.LockRetryBackoff),
+	)
+}
+
+func (app *application) Create(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: migration name is required", ErrInvalidArguments)
+	}
+
+	filePath := app.config.Dir
+
 	files, err := os.ReadDir(filePath)
 	if err != nil {
-		app.logger.Error(err.Error())
-		return
+		return err
 	}
 
-	lastVersion := 0
+	lastVersion := int64(0)
 
 	for _, file := range files {
 		strVersion := regGetVersion.FindString(file.Name())
 
 		if strVersion != "" {
-			version, err := strconv.Atoi(strVersion)
+			version, err := strconv.ParseInt(strVersion, 10, 64)
 			if err != nil {
-				app.logger.Error(err.Error())
-				return
+				return err
 			}
 
 			if version > lastVersion {
@@ -89,202 +143,278 @@ func (app *application) Create(name, filePath string) {
 		}
 	}
 
-	lastVersion++
+	if app.config.Versioning == config.VersioningTimestamp {
+		lastVersion = migration.NewTimestampVersion(time.Now(), lastVersion)
+	} else {
+		lastVersion++
+	}
+
+	if app.config.Type == config.TypeGo {
+		file := path.Join(filePath, fmt.Sprintf("%05d_%s.go", lastVersion, name))
+		err = os.WriteFile(file, []byte(fmt.Sprintf(goTemplate, lastVersion, name, fmt.Sprintf("%05d", lastVersion))), 0777)
+		if err != nil {
+			return err
+		}
+		app.logger.Info(file + " created")
+
+		return nil
+	}
+
+	if app.config.SingleFile {
+		file := path.Join(filePath, fmt.Sprintf("%05d_%s.sql", lastVersion, name))
+		err = os.WriteFile(file, singleFileTemplate, 0777)
+		if err != nil {
+			return err
+		}
+		app.logger.Info(file + " created")
+
+		return nil
+	}
 
 	upFile := path.Join(filePath, fmt.Sprintf("%05d_%s_up.sql", lastVersion, name))
 	err = os.WriteFile(upFile, nil, 0777)
 	if err != nil {
-		app.logger.Error(err.Error())
-		return
+		return err
 	}
 	app.logger.Info(upFile + " created")
 
 	downFile := path.Join(filePath, fmt.Sprintf("%05d_%s_down.sql", lastVersion, name))
 	err = os.WriteFile(downFile, nil, 0777)
 	if err != nil {
-		return
+		return err
 	}
 	app.logger.Info(downFile + " created")
+
+	return nil
 }
 
-func (app *application) Up(filePath, connString string) {
-	migrator := migration.New(connString, app.logger)
-	migrations, err := getMigrations(filePath)
-	if err != nil {
-		app.logger.Error(err.Error())
-		return
+func (app *application) Up() error {
+	if app.config.DryRun {
+		return app.plan(migration.ActionUp, 0)
 	}
 
-	for i := 1; ; i++ {
-		if _, ok := migrations[i]; !ok {
-			break
-		}
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Up(ctx)
+	})
+}
 
-		migrator.Create(migrations[i].Name, migrations[i].Up, migrations[i].Down)
+func (app *application) UpTo(version int64) error {
+	if app.config.DryRun {
+		return app.plan(migration.ActionUpTo, version)
 	}
 
-	ctx := context.Background()
-	if err = migrator.Connect(ctx); err != nil {
-		return
-	}
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.UpTo(ctx, version)
+	})
+}
 
-	if err = migrator.Up(ctx); err != nil {
-		return
+func (app *application) Down() error {
+	if app.config.DryRun {
+		return app.plan(migration.ActionDown, 0)
 	}
 
-	if err = migrator.Close(ctx); err != nil {
-		return
-	}
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Down(ctx)
+	})
 }
 
-func (app *application) Down(filePath, connString string) {
-	migrator := migration.New(connString, app.logger)
-	ctx := context.Background()
-	migrations, err := getMigrations(filePath)
-	if err != nil {
-		app.logger.Error(err.Error())
-		return
+func (app *application) DownTo(version int64) error {
+	if app.config.DryRun {
+		return app.plan(migration.ActionDownTo, version)
 	}
 
-	for i := 1; ; i++ {
-		if _, ok := migrations[i]; !ok {
-			break
-		}
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.DownTo(ctx, version)
+	})
+}
 
-		migrator.Create(migrations[i].Name, migrations[i].Up, migrations[i].Down)
+func (app *application) Redo() error {
+	if app.config.DryRun {
+		return app.plan(migration.ActionRedo, 0)
 	}
 
-	if err = migrator.Connect(ctx); err != nil {
-		return
-	}
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Redo(ctx)
+	})
+}
 
-	if err = migrator.Down(ctx); err != nil {
-		return
-	}
+func (app *application) Validate() error {
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Validate(ctx)
+	})
+}
+
+func (app *application) Repair() error {
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Repair(ctx)
+	})
+}
 
-	if err = migrator.Close(ctx); err != nil {
-		return
+func (app *application) Force(version int64, status string) error {
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Force(ctx, version, status)
+	})
+}
+
+func (app *application) Baseline(version int64) error {
+	if version <= 0 {
+		return fmt.Errorf("%w: baseline version is required", ErrInvalidArguments)
 	}
+
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.Baseline(ctx, version)
+	})
 }
 
-func (app *application) Redo(filePath, connString string) {
-	migrator := migration.New(connString, app.logger)
-	ctx := context.Background()
-	migrations, err := getMigrations(filePath)
+func (app *application) migrate(fn func(context.Context, migration.Migration) ([]migration.StatusRecord, error)) error {
+	migrator, err := app.newMigrator()
 	if err != nil {
-		app.logger.Error(err.Error())
-		return
+		return err
+	}
+
+	if err = app.loadMigrations(migrator); err != nil {
+		return err
 	}
 
-	for i := 1; ; i++ {
-		if _, ok := migrations[i]; !ok {
-			break
+	return app.run(migrator, func(ctx context.Context) error {
+		// Пустой список при ошибке выглядел бы для парсеров вывода как успешный запуск без изменений.
+		records, err := fn(ctx, migrator)
+		if err == nil || len(records) > 0 {
+			if errRender := app.renderStatus(records); errRender != nil && err == nil {
+				err = errRender
+			}
 		}
 
-		migrator.Create(migrations[i].Name, migrations[i].Up, migrations[i].Down)
-	}
+		return err
+	})
+}
 
-	if err = migrator.Connect(ctx); err != nil {
-		return
+// plan выводит шаги, которые выполнила бы команда, не применяя миграции.
+func (app *application) plan(action migration.Action, version int64) error {
+	migrator, err := app.newMigrator()
+	if err != nil {
+		return err
 	}
 
-	if err = migrator.Redo(ctx); err != nil {
-		return
+	if err = app.loadMigrations(migrator); err != nil {
+		return err
 	}
 
-	if err = migrator.Close(ctx); err != nil {
-		return
-	}
-}
+	return app.runReadOnly(migrator, func(ctx context.Context) error {
+		steps, err := migrator.Plan(ctx, action, version)
+		if err != nil {
+			return err
+		}
 
-func (app *application) Status(connString string) {
-	migrator := migration.New(connString, app.logger)
-	ctx := context.Background()
-	var err error
+		return app.renderPlan(steps)
+	})
+}
 
-	if err = migrator.Connect(ctx); err != nil {
-		return
+func (app *application) Status() error {
+	migrator, err := app.newMigrator()
+	if err != nil {
+		return err
 	}
 
-	if err = migrator.Status(ctx); err != nil {
-		return
+	if app.config.Dir != "" {
+		if err = app.loadMigrations(migrator); err != nil {
+			return err
+		}
 	}
 
-	if err = migrator.Close(ctx); err != nil {
-		return
-	}
-}
+	return app.run(migrator, func(ctx context.Context) error {
+		records, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
 
-func (app *application) DbVersion(connString string) {
-	migrator := migration.New(connString, app.logger)
-	ctx := context.Background()
-	var err error
+		return app.renderStatus(records)
+	})
+}
 
-	if err = migrator.Connect(ctx); err != nil {
-		return
+func (app *application) DbVersion() error {
+	migrator, err := app.newMigrator()
+	if err != nil {
+		return err
 	}
 
-	if err = migrator.DbVersion(ctx); err != nil {
-		return
-	}
+	return app.run(migrator, func(ctx context.Context) error {
+		version, err := migrator.DbVersion(ctx)
+		if err != nil {
+			return err
+		}
 
-	if err = migrator.Close(ctx); err != nil {
-		return
-	}
+		return app.renderVersion(version)
+	})
 }
 
-func getMigrations(filePath string) (map[int]*localMigration, error) {
-	files, err := os.ReadDir(filePath)
+func (app *application) History(version int64) error {
+	migrator, err := app.newMigrator()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	migrations := make(map[int]*localMigration)
+	return app.run(migrator, func(ctx context.Context) error {
+		history, err := migrator.History(ctx, version)
+		if err != nil {
+			return err
+		}
+
+		return app.renderHistory(history)
+	})
+}
 
-	for _, file := range files {
-		strVersion := regGetVersion.FindString(file.Name())
+// run подключается к базе, выполняет fn и закрывает соединение даже в случае ошибки.
+func (app *application) run(migrator migration.Migration, fn func(context.Context) error) error {
+	return app.runWith(migrator, migrator.Connect, fn)
+}
 
-		if strVersion != "" {
-			version, err := strconv.Atoi(strVersion)
-			if err != nil {
-				return nil, err
-			}
+// runReadOnly выполняет fn, не создавая и не обновляя служебные таблицы: dry-run ничего не пишет в базу.
+func (app *application) runReadOnly(migrator migration.Migration, fn func(context.Context) error) error {
+	return app.runWith(migrator, migrator.ConnectReadOnly, fn)
+}
 
-			parts := strings.Split(file.Name(), "_")
-			if len(parts) != 3 {
-				return nil, ErrInvalidMigrationName
-			}
+func (app *application) runWith(migrator migration.Migration, connect func(context.Context) error,
+	fn func(context.Context) error,
+) (err error) {
+	ctx := context.Background()
 
-			sql, err := os.ReadFile(path.Join(filePath, file.Name()))
-			if err != nil {
-				return nil, err
-			}
+	if err = connect(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if errClose := migrator.Close(ctx); errClose != nil && err == nil {
+			err = errClose
+		}
+	}()
+
+	return fn(ctx)
+}
+
+func (app *application) loadMigrations(migrator migration.Migration) error {
+	migrations, err := migration.Load(os.DirFS(app.config.Dir))
+	if err != nil {
+		return err
+	}
 
-			if regGetUpMigration.MatchString(file.Name()) {
-				if _, ok := migrations[version]; ok {
-					migrations[version].Up = string(sql)
-				} else {
-					migrations[version] = &localMigration{
-						Version: version,
-						Name:    parts[1],
-						Up:      string(sql),
-					}
-				}
-			} else if regGetDownMigration.MatchString(file.Name()) {
-				if _, ok := migrations[version]; ok {
-					migrations[version].Down = string(sql)
-				} else {
-					migrations[version] = &localMigration{
-						Version: version,
-						Name:    parts[1],
-						Down:    string(sql),
-					}
-				}
-			} else {
-				return nil, ErrInvalidMigrationName
+	for i := range migrations {
+		m := &migrations[i]
+		for _, source := range []*migration.Source{&m.UpSource, &m.DownSource} {
+			if source.File != "" {
+				source.File = path.Join(app.config.Dir, source.File)
 			}
 		}
 	}
 
-	return migrations, nil
+	// Шаблоны раскрываются до Add: контрольная сумма и --dry-run видят итоговый SQL.
+	if migrations, err = migration.Render(migrations, app.config.Vars); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		migrator.Add(m)
+	}
+
+	return nil
 }
@@ -1,11 +1,18 @@
 package app
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/MyLi2tlePony/sql-migrator/internal/config"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	_ "github.com/MyLi2tlePony/sql-migrator/pkg/storage/sqlite"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slices"
 )
@@ -28,16 +35,17 @@ func TestCreateMigrations(t *testing.T) {
 
 		app := application{
 			logger: &logg{},
+			config: config.Config{Dir: dir},
 		}
 
 		file1 := "init"
-		app.Create(file1, dir)
+		require.Nil(t, app.Create(file1))
 
 		file2 := "new"
-		app.Create(file2, dir)
+		require.Nil(t, app.Create(file2))
 
 		file3 := "add"
-		app.Create(file3, dir)
+		require.Nil(t, app.Create(file3))
 
 		files, err := os.ReadDir(dir)
 		require.Nil(t, err)
@@ -60,6 +68,102 @@ func TestCreateMigrations(t *testing.T) {
 	})
 }
 
+func TestCreateGoMigration(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrations")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	app := application{
+		logger: &logg{},
+		config: config.Config{Dir: dir, Type: config.TypeGo},
+	}
+	require.Nil(t, app.Create("backfill"))
+
+	content, err := os.ReadFile(filepath.Join(dir, "00001_backfill.go"))
+	require.Nil(t, err)
+	require.Contains(t, string(content), `migration.Register(1, "backfill", up00001, down00001)`)
+
+	migrations, err := migration.Load(os.DirFS(dir))
+	require.Nil(t, err)
+	require.Empty(t, migrations)
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+
+	app := application{
+		logger: &logg{},
+		config: config.Config{Dir: dir, SingleFile: true, Versioning: config.VersioningSequential},
+	}
+	require.Nil(t, app.Create("init"))
+
+	app.config.Type = config.TypeGo
+	require.Nil(t, app.Create("backfill"))
+
+	require.Nil(t, app.Convert())
+	require.Nil(t, app.Convert())
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"20000101000001_init.sql", "20000101000002_backfill.go"}, names)
+
+	content, err := os.ReadFile(filepath.Join(dir, "20000101000002_backfill.go"))
+	require.Nil(t, err)
+	require.Contains(t, string(content), `migration.Register(20000101000002, "backfill", up00002, down00002)`)
+
+	app.config.Type = config.TypeSQL
+	app.config.Versioning = config.VersioningTimestamp
+	require.Nil(t, app.Create("users"))
+	require.Nil(t, app.Create("orders"))
+
+	migrations, err := migration.Load(os.DirFS(dir))
+	require.Nil(t, err)
+	require.Len(t, migrations, 3)
+	require.Equal(t, "users", migrations[1].Name)
+	require.True(t, migration.IsTimestampVersion(migrations[1].Version))
+	require.Greater(t, migrations[2].Version, migrations[1].Version)
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "00003_custom.go"), []byte("package migrations\n"), 0666))
+	require.ErrorIs(t, app.Convert(), ErrGoMigrationVersion)
+}
+
+func TestMigrateOutput(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "00001_init.sql"), []byte("-- +up\nCREATE TABLE t (id INTEGER);\n-- +down\nDROP TABLE t;\n"), 0666))
+
+	out := &bytes.Buffer{}
+	app := application{
+		logger: &logg{},
+		config: config.Config{Dir: dir, DSN: "sqlite://" + filepath.Join(dir, "app.db"), Format: config.FormatJSON},
+		out:    out,
+	}
+
+	app.config.DryRun = true
+	require.Nil(t, app.Up())
+	require.Contains(t, out.String(), "init")
+
+	_, err := os.Stat(filepath.Join(dir, "app.db"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	out.Reset()
+	app.config.DryRun = false
+	require.Nil(t, app.Up())
+
+	var outputs []map[string]interface{}
+	require.Nil(t, json.Unmarshal(out.Bytes(), &outputs))
+	require.Len(t, outputs, 1)
+
+	out.Reset()
+	require.ErrorIs(t, app.Baseline(1), migration.ErrBaselineNotEmpty)
+	require.ErrorIs(t, app.UpTo(5), migration.ErrUnknownTargetVersion)
+	require.Empty(t, out.String())
+}
+
 func TestGetMigrations(t *testing.T) {
 	t.Run("necessary case", func(t *testing.T) {
 		var err error
@@ -73,8 +177,9 @@ func TestGetMigrations(t *testing.T) {
 		fileName := "init"
 		app := application{
 			logger: &logg{},
+			config: config.Config{Dir: dir},
 		}
-		app.Create(fileName, dir)
+		require.Nil(t, app.Create(fileName))
 
 		up := "CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";"
 		upFile := fmt.Sprintf("%05d_%s_up.sql", 1, fileName)
@@ -86,15 +191,165 @@ func TestGetMigrations(t *testing.T) {
 		err = os.WriteFile(filepath.Join(dir, dowFile), []byte(down), 0777)
 		require.Nil(t, err)
 
-		migrations, err := getMigrations(dir)
+		migrations, err := migration.Load(os.DirFS(dir))
 		require.Nil(t, err)
+		require.Len(t, migrations, 1)
 
-		m, ok := migrations[1]
-		require.True(t, ok)
+		m := migrations[0]
 
 		require.Equal(t, up, m.Up)
 		require.Equal(t, down, m.Down)
-		require.Equal(t, 1, m.Version)
+		require.Equal(t, int64(1), m.Version)
 		require.Equal(t, fileName, m.Name)
 	})
 }
+
+func TestGetSingleFileMigrations(t *testing.T) {
+	t.Run("necessary case", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "migrations")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		app := application{
+			logger: &logg{},
+			config: config.Config{Dir: dir, SingleFile: true},
+		}
+		require.Nil(t, app.Create("add_users"))
+
+		file := filepath.Join(dir, fmt.Sprintf("%05d_%s.sql", 1, "add_users"))
+		content, err := os.ReadFile(file)
+		require.Nil(t, err)
+		require.Equal(t, "-- +up\n\n-- +down\n", string(content))
+
+		content = []byte("-- users table\n-- +up\nCREATE TABLE users (id INTEGER);\n-- +down\nDROP TABLE users;\n")
+		require.Nil(t, os.WriteFile(file, content, 0777))
+
+		migrations, err := migration.Load(os.DirFS(dir))
+		require.Nil(t, err)
+		require.Len(t, migrations, 1)
+
+		m := migrations[0]
+
+		require.Equal(t, "CREATE TABLE users (id INTEGER);\n", m.Up)
+		require.Equal(t, "DROP TABLE users;\n", m.Down)
+		require.Equal(t, int64(1), m.Version)
+		require.Equal(t, "add_users", m.Name)
+	})
+}
+
+func TestRender(t *testing.T) {
+	changeTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	records := []migration.StatusRecord{
+		{Version: 2, Name: "add", Status: "отменена", StatusChangeTime: changeTime},
+		{Version: 1, Name: "init", Status: "применена", StatusChangeTime: changeTime, AppliedTime: changeTime, Duration: 1500 * time.Millisecond},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatJSON}, out: out}
+		require.Nil(t, app.renderStatus(records))
+
+		var outputs []map[string]interface{}
+		require.Nil(t, json.Unmarshal(out.Bytes(), &outputs))
+		require.Len(t, outputs, 2)
+		require.Nil(t, outputs[0]["applied_time"])
+		require.Equal(t, "init", outputs[1]["name"])
+		require.Equal(t, float64(1500), outputs[1]["duration_ms"])
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatCSV}, out: out}
+		require.Nil(t, app.renderStatus(records))
+
+		require.Equal(t, "version,name,status,status_change_time,applied_time,checksum,checksum_mismatch,duration_ms,"+
+			"error_code,error_message,error_detail,error_hint,error_position,error_file,error_line\n"+
+			"2,add,отменена,2022-10-01T12:00:00Z,,,false,0,,,,,,,\n"+
+			"1,init,применена,2022-10-01T12:00:00Z,2022-10-01T12:00:00Z,,false,1500,,,,,,,\n", out.String())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		failed := []migration.StatusRecord{{
+			Version: 3, Name: "broken", Status: "ошибка", StatusChangeTime: changeTime,
+			Failure: entity.Failure{
+				Code: "42601", Message: `syntax error at or near "SELEC"`, Hint: "check it", Position: 7,
+				File: "db/00003_broken.sql", Line: 4,
+			},
+		}}
+
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatTable}, out: out}
+		require.Nil(t, app.renderStatus(failed))
+		require.Contains(t, out.String(), "Ошибка миграции 3 broken:\n"+
+			"  syntax error at or near \"SELEC\" (SQLSTATE 42601)\n"+
+			"  запрос: db/00003_broken.sql:4\n"+
+			"  подсказка: check it\n")
+
+		out.Reset()
+		app.config.Format = config.FormatJSON
+		require.Nil(t, app.renderStatus(append(failed, records...)))
+
+		var outputs []map[string]interface{}
+		require.Nil(t, json.Unmarshal(out.Bytes(), &outputs))
+		require.Equal(t, float64(4), outputs[0]["error"].(map[string]interface{})["line"])
+		require.NotContains(t, outputs[1], "error")
+	})
+
+	t.Run("repeatable", func(t *testing.T) {
+		repeatable := append(records, migration.StatusRecord{
+			Version: migration.RepeatableVersion, Name: "views", Status: "применена", StatusChangeTime: changeTime,
+			ChecksumMismatch: true,
+		})
+
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatTable}, out: out}
+		require.Nil(t, app.renderStatus(repeatable))
+		require.Contains(t, out.String(), "| R       | views               | применена, изменена |")
+
+		out.Reset()
+		app.config.Format = config.FormatJSON
+		require.Nil(t, app.renderStatus(repeatable))
+
+		var outputs []map[string]interface{}
+		require.Nil(t, json.Unmarshal(out.Bytes(), &outputs))
+		require.Equal(t, true, outputs[2]["repeatable"])
+		require.NotContains(t, outputs[0], "repeatable")
+	})
+
+	t.Run("yaml version", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatYAML}, out: out}
+		require.Nil(t, app.renderVersion(7))
+		require.Equal(t, "version: 7\n", out.String())
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		steps := []migration.PlanStep{
+			{Version: 1, Name: "init", Direction: migration.DirectionUp, SQL: "CREATE TABLE t();\n"},
+			{Version: 2, Name: "backfill", Direction: migration.DirectionUp, Go: true},
+		}
+
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatTable}, out: out}
+		require.Nil(t, app.renderPlan(steps))
+		require.Equal(t, "-- 1/2: up 1 init\nCREATE TABLE t();\n\n-- 2/2: up 2 backfill\n-- go migration\n\n", out.String())
+
+		out.Reset()
+		require.Nil(t, app.renderPlan(nil))
+		require.Equal(t, "-- nothing to do\n", out.String())
+	})
+
+	t.Run("history csv", func(t *testing.T) {
+		history := []entity.History{
+			{Version: 1, Name: "init", Status: "применена", Time: changeTime, Duration: time.Second, Hostname: "host", User: "deploy"},
+			{Version: 2, Name: "add", Status: "ошибка", Time: changeTime, Error: "syntax error"},
+		}
+
+		out := &bytes.Buffer{}
+		app := application{logger: &logg{}, config: config.Config{Format: config.FormatCSV}, out: out}
+		require.Nil(t, app.renderHistory(history))
+		require.Equal(t, "version,name,status,time,duration_ms,hostname,user,tool_version,error\n"+
+			"1,init,применена,2022-10-01T12:00:00Z,1000,host,deploy,,\n"+
+			"2,add,ошибка,2022-10-01T12:00:00Z,0,,,,syntax error\n", out.String())
+	})
+}
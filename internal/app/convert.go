@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/MyLi2tlePony/sql-migrator/internal/config"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+)
+
+var (
+	ErrGoMigrationVersion = errors.New("migration.Register call with the file version not found")
+
+	regGoRegister = regexp.MustCompile(`(migration\.Register\(\s*)(\d+)(\s*,)`)
+)
+
+// Convert переводит порядковые версии в метки времени: переименовывает файлы миграций и, если
+// задана база, записи в ней. Отображение версий фиксированное, поэтому команду можно запускать
+// на каждой базе отдельно, а уже переведённые файлы и записи пропускаются.
+func (app *application) Convert() error {
+	if err := app.convertFiles(); err != nil {
+		return err
+	}
+
+	if app.config.Versioning != config.VersioningTimestamp {
+		app.logger.Info("Set versioning to timestamp to create new migrations with timestamp versions")
+	}
+
+	if app.config.DSN == "" {
+		app.logger.Info("Database is not set, only files are converted")
+		return nil
+	}
+
+	return app.migrate(func(ctx context.Context, migrator migration.Migration) ([]migration.StatusRecord, error) {
+		return migrator.ConvertVersions(ctx)
+	})
+}
+
+func (app *application) convertFiles() error {
+	files, err := os.ReadDir(app.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		strVersion := regGetVersion.FindString(file.Name())
+		if file.IsDir() || strVersion == "" {
+			continue
+		}
+
+		version, err := strconv.ParseInt(strVersion, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if migration.IsTimestampVersion(version) {
+			continue
+		}
+
+		converted := migration.TimestampVersion(version)
+		oldPath := path.Join(app.config.Dir, file.Name())
+		newPath := path.Join(app.config.Dir, strconv.FormatInt(converted, 10)+strings.TrimPrefix(file.Name(), strVersion))
+
+		if strings.HasSuffix(file.Name(), ".go") {
+			if err = convertGoMigration(oldPath, version, converted); err != nil {
+				return err
+			}
+		}
+
+		if err = os.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+
+		app.logger.Info(fmt.Sprintf("%s renamed to %s", oldPath, newPath))
+	}
+
+	return nil
+}
+
+// convertGoMigration меняет версию в вызове migration.Register, созданном по шаблону create.
+func convertGoMigration(file string, version, converted int64) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	found := false
+
+	content = regGoRegister.ReplaceAllFunc(content, func(call []byte) []byte {
+		parts := regGoRegister.FindSubmatch(call)
+		if registered, _ := strconv.ParseInt(string(parts[2]), 10, 64); registered != version {
+			return call
+		}
+
+		found = true
+		return []byte(string(parts[1]) + strconv.FormatInt(converted, 10) + string(parts[3]))
+	})
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrGoMigrationVersion, file)
+	}
+
+	return os.WriteFile(file, content, 0777)
+}
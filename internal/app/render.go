@@ -0,0 +1,409 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/internal/config"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	"gopkg.in/yaml.v3"
+)
+
+type statusOutput struct {
+	Version          int64        `json:"version" yaml:"version"`
+	Name             string       `json:"name" yaml:"name"`
+	Status           string       `json:"status" yaml:"status"`
+	StatusChangeTime time.Time    `json:"status_change_time" yaml:"status_change_time"`
+	AppliedTime      *time.Time   `json:"applied_time" yaml:"applied_time"`
+	Checksum         string       `json:"checksum" yaml:"checksum"`
+	ChecksumMismatch bool         `json:"checksum_mismatch" yaml:"checksum_mismatch"`
+	DurationMs       int64        `json:"duration_ms" yaml:"duration_ms"`
+	Repeatable       bool         `json:"repeatable,omitempty" yaml:"repeatable,omitempty"`
+	Error            *errorOutput `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type errorOutput struct {
+	Code     string `json:"code,omitempty" yaml:"code,omitempty"`
+	Message  string `json:"message" yaml:"message"`
+	Detail   string `json:"detail,omitempty" yaml:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty" yaml:"hint,omitempty"`
+	Position int    `json:"position,omitempty" yaml:"position,omitempty"`
+	File     string `json:"file,omitempty" yaml:"file,omitempty"`
+	Line     int    `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+type planOutput struct {
+	Version       int64  `json:"version" yaml:"version"`
+	Name          string `json:"name" yaml:"name"`
+	Direction     string `json:"direction" yaml:"direction"`
+	SQL           string `json:"sql" yaml:"sql"`
+	Go            bool   `json:"go" yaml:"go"`
+	NoTransaction bool   `json:"no_transaction" yaml:"no_transaction"`
+}
+
+type historyOutput struct {
+	Version     int64     `json:"version" yaml:"version"`
+	Name        string    `json:"name" yaml:"name"`
+	Status      string    `json:"status" yaml:"status"`
+	Time        time.Time `json:"time" yaml:"time"`
+	DurationMs  int64     `json:"duration_ms" yaml:"duration_ms"`
+	Hostname    string    `json:"hostname" yaml:"hostname"`
+	User        string    `json:"user" yaml:"user"`
+	ToolVersion string    `json:"tool_version" yaml:"tool_version"`
+	Error       string    `json:"error" yaml:"error"`
+}
+
+type versionOutput struct {
+	Version int64 `json:"version" yaml:"version"`
+}
+
+const (
+	timeLayout = "2006-01-02 15:04:05"
+
+	statusChecksumMismatch = "изменена"
+)
+
+var (
+	statusColumns = []string{
+		"version", "name", "status", "status_change_time", "applied_time", "checksum", "checksum_mismatch", "duration_ms",
+		"error_code", "error_message", "error_detail", "error_hint", "error_position", "error_file", "error_line",
+	}
+	planColumns    = []string{"version", "name", "direction", "sql", "go", "no_transaction"}
+	historyColumns = []string{
+		"version", "name", "status", "time", "duration_ms", "hostname", "user", "tool_version", "error",
+	}
+)
+
+func newStatusOutput(record migration.StatusRecord) statusOutput {
+	output := statusOutput{
+		Version:          record.Version,
+		Name:             record.Name,
+		Status:           record.Status,
+		StatusChangeTime: record.StatusChangeTime,
+		Checksum:         record.Checksum,
+		ChecksumMismatch: record.ChecksumMismatch,
+		DurationMs:       record.Duration.Milliseconds(),
+		Repeatable:       record.Version == migration.RepeatableVersion,
+	}
+
+	if !record.AppliedTime.IsZero() {
+		appliedTime := record.AppliedTime
+		output.AppliedTime = &appliedTime
+	}
+
+	if record.Failure.Message != "" {
+		failure := errorOutput(record.Failure)
+		output.Error = &failure
+	}
+
+	return output
+}
+
+func (app *application) renderStatus(records []migration.StatusRecord) error {
+	outputs := make([]statusOutput, 0, len(records))
+	for _, record := range records {
+		outputs = append(outputs, newStatusOutput(record))
+	}
+
+	switch app.config.Format {
+	case config.FormatJSON:
+		return renderJSON(app.out, outputs)
+	case config.FormatYAML:
+		return renderYAML(app.out, outputs)
+	case config.FormatCSV:
+		rows := make([][]string, 0, len(outputs))
+		for _, output := range outputs {
+			appliedTime := ""
+			if output.AppliedTime != nil {
+				appliedTime = output.AppliedTime.Format(time.RFC3339)
+			}
+
+			failure := errorOutput{}
+			if output.Error != nil {
+				failure = *output.Error
+			}
+
+			rows = append(rows, []string{
+				strconv.FormatInt(output.Version, 10),
+				output.Name,
+				output.Status,
+				output.StatusChangeTime.Format(time.RFC3339),
+				appliedTime,
+				output.Checksum,
+				strconv.FormatBool(output.ChecksumMismatch),
+				strconv.FormatInt(output.DurationMs, 10),
+				failure.Code,
+				failure.Message,
+				failure.Detail,
+				failure.Hint,
+				formatNonZero(failure.Position),
+				failure.File,
+				formatNonZero(failure.Line),
+			})
+		}
+
+		return renderCSV(app.out, statusColumns, rows)
+	default:
+		return renderStatusTable(app.out, outputs)
+	}
+}
+
+func (app *application) renderPlan(steps []migration.PlanStep) error {
+	outputs := make([]planOutput, 0, len(steps))
+	for _, step := range steps {
+		outputs = append(outputs, planOutput(step))
+	}
+
+	switch app.config.Format {
+	case config.FormatJSON:
+		return renderJSON(app.out, outputs)
+	case config.FormatYAML:
+		return renderYAML(app.out, outputs)
+	case config.FormatCSV:
+		rows := make([][]string, 0, len(outputs))
+		for _, output := range outputs {
+			rows = append(rows, []string{
+				strconv.FormatInt(output.Version, 10),
+				output.Name,
+				output.Direction,
+				output.SQL,
+				strconv.FormatBool(output.Go),
+				strconv.FormatBool(output.NoTransaction),
+			})
+		}
+
+		return renderCSV(app.out, planColumns, rows)
+	default:
+		return renderPlanText(app.out, outputs)
+	}
+}
+
+func (app *application) renderHistory(history []entity.History) error {
+	outputs := make([]historyOutput, 0, len(history))
+	for _, entry := range history {
+		outputs = append(outputs, historyOutput{
+			Version:     entry.Version,
+			Name:        entry.Name,
+			Status:      entry.Status,
+			Time:        entry.Time,
+			DurationMs:  entry.Duration.Milliseconds(),
+			Hostname:    entry.Hostname,
+			User:        entry.User,
+			ToolVersion: entry.ToolVersion,
+			Error:       entry.Error,
+		})
+	}
+
+	switch app.config.Format {
+	case config.FormatJSON:
+		return renderJSON(app.out, outputs)
+	case config.FormatYAML:
+		return renderYAML(app.out, outputs)
+	case config.FormatCSV:
+		rows := make([][]string, 0, len(outputs))
+		for _, output := range outputs {
+			rows = append(rows, []string{
+				strconv.FormatInt(output.Version, 10),
+				output.Name,
+				output.Status,
+				output.Time.Format(time.RFC3339),
+				strconv.FormatInt(output.DurationMs, 10),
+				output.Hostname,
+				output.User,
+				output.ToolVersion,
+				output.Error,
+			})
+		}
+
+		return renderCSV(app.out, historyColumns, rows)
+	default:
+		return renderHistoryTable(app.out, outputs)
+	}
+}
+
+func (app *application) renderVersion(version int64) error {
+	output := versionOutput{Version: version}
+
+	switch app.config.Format {
+	case config.FormatJSON:
+		return renderJSON(app.out, output)
+	case config.FormatYAML:
+		return renderYAML(app.out, output)
+	case config.FormatCSV:
+		return renderCSV(app.out, []string{"version"}, [][]string{{strconv.FormatInt(version, 10)}})
+	default:
+		_, err := fmt.Fprintf(app.out, "Version: %d\n", version)
+		return err
+	}
+}
+
+func renderStatusTable(w io.Writer, outputs []statusOutput) error {
+	// Версии-метки времени длиннее колонки по умолчанию, поэтому ширина подбирается по самой длинной.
+	width := 7
+	for _, output := range outputs {
+		if n := len(formatVersion(output.Version)); n > width {
+			width = n
+		}
+	}
+
+	border := strings.Repeat("_", width+2)
+
+	lines := []string{
+		"." + border + "._____________________._____________________._____________________.",
+		fmt.Sprintf("| %-*s | %-19s | %-19s | %-19s |", width, "Версия", "Название", "Статус", "Время"),
+	}
+
+	for _, output := range outputs {
+		status := output.Status
+		if output.ChecksumMismatch {
+			status += ", " + statusChecksumMismatch
+		}
+
+		lines = append(lines, fmt.Sprintf("| %-*s | %-19s | %-19s | %s |",
+			width, formatVersion(output.Version), output.Name, status, output.StatusChangeTime.Format(timeLayout)))
+	}
+
+	lines = append(lines, "|"+border+"|_____________________|_____________________|_____________________|")
+
+	for _, output := range outputs {
+		if output.Error != nil {
+			lines = append(lines, "", fmt.Sprintf("Ошибка миграции %s %s:", formatVersion(output.Version), output.Name))
+			lines = append(lines, formatError(*output.Error)...)
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatError(failure errorOutput) []string {
+	message := failure.Message
+	if failure.Code != "" {
+		message += " (SQLSTATE " + failure.Code + ")"
+	}
+
+	lines := []string{"  " + message}
+
+	switch {
+	case failure.File != "" && failure.Line > 0:
+		lines = append(lines, fmt.Sprintf("  запрос: %s:%d", failure.File, failure.Line))
+	case failure.Line > 0:
+		lines = append(lines, fmt.Sprintf("  запрос: строка %d", failure.Line))
+	}
+
+	if failure.Detail != "" {
+		lines = append(lines, "  подробности: "+failure.Detail)
+	}
+
+	if failure.Hint != "" {
+		lines = append(lines, "  подсказка: "+failure.Hint)
+	}
+
+	return lines
+}
+
+// formatVersion выводит повторяемые миграции с версией R, как в именах их файлов.
+func formatVersion(version int64) string {
+	if version == migration.RepeatableVersion {
+		return "R"
+	}
+
+	return strconv.FormatInt(version, 10)
+}
+
+func formatNonZero(value int) string {
+	if value == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(value)
+}
+
+func renderHistoryTable(w io.Writer, outputs []historyOutput) error {
+	lines := []string{
+		fmt.Sprintf("%-7s  %-19s  %-19s  %-19s  %-8s  %-15s  %s",
+			"Версия", "Название", "Статус", "Время", "Мс", "Кто", "Ошибка"),
+	}
+
+	for _, output := range outputs {
+		lines = append(lines, fmt.Sprintf("%-7s  %-19s  %-19s  %s  %-8d  %-15s  %s",
+			formatVersion(output.Version), output.Name, output.Status, output.Time.Format(timeLayout), output.DurationMs,
+			output.User+"@"+output.Hostname, strings.ReplaceAll(output.Error, "\n", " ")))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderPlanText(w io.Writer, outputs []planOutput) error {
+	if len(outputs) == 0 {
+		_, err := fmt.Fprintln(w, "-- nothing to do")
+		return err
+	}
+
+	for i, output := range outputs {
+		header := fmt.Sprintf("-- %d/%d: %s %s %s", i+1, len(outputs), output.Direction, formatVersion(output.Version), output.Name)
+		if output.NoTransaction {
+			header += " (no transaction)"
+		}
+
+		sql := strings.TrimSpace(output.SQL)
+		if output.Go {
+			sql = "-- go migration"
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\n%s\n\n", header, sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func renderYAML(w io.Writer, v interface{}) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func renderCSV(w io.Writer, header []string, rows [][]string) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
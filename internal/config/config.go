@@ -0,0 +1,307 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/migration"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	DSN         string
+	Dir         string
+	Type        string
+	Schema      string
+	Table       string
+	LockTimeout time.Duration
+	SingleFile  bool
+	Format      string
+	DryRun      bool
+	Versioning  string
+
+	// StatementTimeout и DBLockTimeout выставляются в сессии перед каждой миграцией,
+	// LockTimeout ограничивает ожидание блокировки самого мигратора.
+	StatementTimeout time.Duration
+	DBLockTimeout    time.Duration
+	LockRetries      int
+	LockRetryBackoff time.Duration
+
+	// Vars подставляются в шаблоны SQL-миграций.
+	Vars map[string]string
+
+	AllowOutOfOrder bool
+}
+
+type fileConfig struct {
+	DSN         string `yaml:"dsn" json:"dsn"`
+	Dir         string `yaml:"dir" json:"dir"`
+	Type        string `yaml:"type" json:"type"`
+	Schema      string `yaml:"schema" json:"schema"`
+	Table       string `yaml:"table" json:"table"`
+	LockTimeout string `yaml:"lock_timeout" json:"lock_timeout"`
+	SingleFile  bool   `yaml:"single_file" json:"single_file"`
+	Format      string `yaml:"format" json:"format"`
+	Versioning  string `yaml:"versioning" json:"versioning"`
+
+	StatementTimeout string `yaml:"statement_timeout" json:"statement_timeout"`
+	DBLockTimeout    string `yaml:"db_lock_timeout" json:"db_lock_timeout"`
+	LockRetries      int    `yaml:"lock_retries" json:"lock_retries"`
+	LockRetryBackoff string `yaml:"lock_retry_backoff" json:"lock_retry_backoff"`
+
+	Vars map[string]string `yaml:"vars" json:"vars"`
+
+	AllowOutOfOrder bool `yaml:"allow_out_of_order" json:"allow_out_of_order"`
+}
+
+const (
+	TypeSQL = "sql"
+	TypeGo  = "go"
+
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+
+	VersioningSequential = "sequential"
+	VersioningTimestamp  = "timestamp"
+
+	// envVarPrefix — префикс переменных окружения с переменными шаблонов: var_role=app.
+	envVarPrefix = "var_"
+)
+
+var (
+	ErrUnknownType       = errors.New("unknown migration type")
+	ErrUnknownFormat     = errors.New("unknown output format")
+	ErrUnknownFileType   = errors.New("unknown config file type")
+	ErrUnknownVersioning = errors.New("unknown versioning scheme")
+	ErrNegativeValue     = errors.New("negative value")
+)
+
+func Default() Config {
+	return Config{
+		Type:        TypeSQL,
+		LockTimeout: migration.DefaultLockTimeout,
+		Format:      FormatTable,
+		Versioning:  VersioningSequential,
+	}
+}
+
+// Load читает конфигурацию из YAML или JSON файла. Все строковые значения
+// проходят через os.ExpandEnv, поэтому можно писать "dsn: $DB_DSN".
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var file fileConfig
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	default:
+		return Config{}, fmt.Errorf("%w: %s", ErrUnknownFileType, path)
+	}
+
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := Config{
+		DSN:        os.ExpandEnv(file.DSN),
+		Dir:        os.ExpandEnv(file.Dir),
+		Type:       os.ExpandEnv(file.Type),
+		Schema:     os.ExpandEnv(file.Schema),
+		Table:      os.ExpandEnv(file.Table),
+		SingleFile: file.SingleFile,
+		Format:     os.ExpandEnv(file.Format),
+		Versioning: os.ExpandEnv(file.Versioning),
+
+		LockRetries: file.LockRetries,
+
+		AllowOutOfOrder: file.AllowOutOfOrder,
+	}
+
+	for key, value := range file.Vars {
+		config.SetVar(key, os.ExpandEnv(value))
+	}
+
+	durations := []struct {
+		key   string
+		value string
+		dest  *time.Duration
+	}{
+		{"lock_timeout", file.LockTimeout, &config.LockTimeout},
+		{"statement_timeout", file.StatementTimeout, &config.StatementTimeout},
+		{"db_lock_timeout", file.DBLockTimeout, &config.DBLockTimeout},
+		{"lock_retry_backoff", file.LockRetryBackoff, &config.LockRetryBackoff},
+	}
+
+	for _, duration := range durations {
+		if value := os.ExpandEnv(duration.value); value != "" {
+			if *duration.dest, err = time.ParseDuration(value); err != nil {
+				return Config{}, fmt.Errorf("%s: %s: %w", path, duration.key, err)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+func FromEnv() (config Config, err error) {
+	config = Config{
+		DSN:        os.Getenv("database"),
+		Dir:        os.Getenv("path"),
+		Type:       os.Getenv("type"),
+		Schema:     os.Getenv("schema"),
+		Table:      os.Getenv("table"),
+		Format:     os.Getenv("format"),
+		Versioning: os.Getenv("versioning"),
+	}
+
+	durations := map[string]*time.Duration{
+		"lock_timeout":       &config.LockTimeout,
+		"statement_timeout":  &config.StatementTimeout,
+		"db_lock_timeout":    &config.DBLockTimeout,
+		"lock_retry_backoff": &config.LockRetryBackoff,
+	}
+
+	for key, dest := range durations {
+		if value := os.Getenv(key); value != "" {
+			if *dest, err = time.ParseDuration(value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	for _, env := range os.Environ() {
+		if key, value, ok := strings.Cut(env, "="); ok && strings.HasPrefix(key, envVarPrefix) {
+			config.SetVar(strings.TrimPrefix(key, envVarPrefix), value)
+		}
+	}
+
+	if lockRetries := os.Getenv("lock_retries"); lockRetries != "" {
+		if config.LockRetries, err = strconv.Atoi(lockRetries); err != nil {
+			return Config{}, fmt.Errorf("lock_retries: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Merge возвращает копию конфигурации, в которой заданные (ненулевые) поля other
+// перекрывают текущие значения.
+func (c Config) Merge(other Config) Config {
+	if other.DSN != "" {
+		c.DSN = other.DSN
+	}
+
+	if other.Dir != "" {
+		c.Dir = other.Dir
+	}
+
+	if other.Type != "" {
+		c.Type = other.Type
+	}
+
+	if other.Schema != "" {
+		c.Schema = other.Schema
+	}
+
+	if other.Table != "" {
+		c.Table = other.Table
+	}
+
+	if other.LockTimeout != 0 {
+		c.LockTimeout = other.LockTimeout
+	}
+
+	if other.SingleFile {
+		c.SingleFile = true
+	}
+
+	if other.Format != "" {
+		c.Format = other.Format
+	}
+
+	if other.Versioning != "" {
+		c.Versioning = other.Versioning
+	}
+
+	if other.StatementTimeout != 0 {
+		c.StatementTimeout = other.StatementTimeout
+	}
+
+	if other.DBLockTimeout != 0 {
+		c.DBLockTimeout = other.DBLockTimeout
+	}
+
+	if other.LockRetries != 0 {
+		c.LockRetries = other.LockRetries
+	}
+
+	if other.LockRetryBackoff != 0 {
+		c.LockRetryBackoff = other.LockRetryBackoff
+	}
+
+	for key, value := range other.Vars {
+		c.SetVar(key, value)
+	}
+
+	if other.DryRun {
+		c.DryRun = true
+	}
+
+	if other.AllowOutOfOrder {
+		c.AllowOutOfOrder = true
+	}
+
+	return c
+}
+
+// SetVar задаёт переменную шаблонов миграций. Карта копируется, чтобы не менять конфигурацию,
+// из которой Merge сделал копию.
+func (c *Config) SetVar(key, value string) {
+	vars := make(map[string]string, len(c.Vars)+1)
+	for k, v := range c.Vars {
+		vars[k] = v
+	}
+
+	vars[key] = value
+	c.Vars = vars
+}
+
+func (c Config) Validate() error {
+	switch c.Type {
+	case TypeSQL, TypeGo:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownType, c.Type)
+	}
+
+	switch c.Format {
+	case FormatTable, FormatJSON, FormatYAML, FormatCSV:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, c.Format)
+	}
+
+	switch c.Versioning {
+	case VersioningSequential, VersioningTimestamp:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownVersioning, c.Versioning)
+	}
+
+	if c.StatementTimeout < 0 || c.DBLockTimeout < 0 || c.LockRetries < 0 || c.LockRetryBackoff < 0 {
+		return fmt.Errorf("%w: statement_timeout, db_lock_timeout, lock_retries and lock_retry_backoff", ErrNegativeValue)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+var (
+	ErrBaseline         = errors.New("error baseline")
+	ErrBaselineNotEmpty = errors.New("migrations table is not empty")
+	ErrBaselineRollback = errors.New("baselined migrations cannot be rolled back")
+)
+
+// Baseline отмечает миграции до версии включительно статусом "базовая", не выполняя их. Нужен, чтобы
+// перевести под управление мигратора базу, схема которой создана без него. Версионных записей
+// в таблице ещё быть не должно. Базовые миграции считаются применёнными, но не откатываются.
+func (m *migrator) Baseline(ctx context.Context, version int64) ([]StatusRecord, error) {
+	m.logger.Info(fmt.Sprintf("Baseline to version %d start", version))
+
+	if m.find(version) == nil {
+		return nil, m.logError(ErrBaseline, fmt.Errorf("%w: %d", ErrUnknownTargetVersion, version))
+	}
+
+	err := m.withLock(ctx, ErrBaseline, func() error {
+		migrations, err := m.storage.SelectMigrations(ctx)
+		if err != nil && err != storage.ErrMigrationNotFound {
+			return err
+		}
+
+		if len(migrations) > 0 {
+			return fmt.Errorf("%w: %d migrations recorded", ErrBaselineNotEmpty, len(migrations))
+		}
+
+		return m.storage.Transaction(ctx, func(tx storage.Storage) error {
+			now := time.Now()
+
+			for i := range m.migrations {
+				baselined := &m.migrations[i]
+				if baselined.version > version {
+					break
+				}
+
+				baselined.SetStatus(storage.StatusBaseline)
+				baselined.SetStatusChangeTime(now)
+				baselined.SetAppliedTime(time.Time{})
+				baselined.SetDuration(0)
+				baselined.SetFailure(entity.Failure{})
+
+				if err := m.saveStatus(ctx, tx, baselined, nil); err != nil {
+					return err
+				}
+
+				m.processed = append(m.processed, newStatusRecord(baselined))
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info(fmt.Sprintf("Baseline to version %d end", version))
+	return m.processed, nil
+}
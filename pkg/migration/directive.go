@@ -0,0 +1,102 @@
+package migration
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+)
+
+type directives struct {
+	noTransaction bool
+	template      bool
+
+	// statementTimeout и lockTimeout перекрывают настройки мигратора, если заданы.
+	statementTimeout *time.Duration
+	lockTimeout      *time.Duration
+}
+
+const (
+	directiveNoTransaction    = "NoTransaction"
+	directiveTemplate         = "Template"
+	directiveStatementTimeout = "StatementTimeout"
+	directiveLockTimeout      = "LockTimeout"
+)
+
+var (
+	ErrUnknownDirective      = errors.New("unknown directive")
+	ErrInvalidDirectiveValue = errors.New("invalid directive value")
+
+	regDirective = regexp.MustCompile(`^--\s*\+migrate\s+(\S+)\s*(.*)$`)
+)
+
+// parseDirectives читает директивы вида "-- +migrate <Name>" из заголовка миграции,
+// то есть из комментариев и пустых строк до первой SQL-инструкции.
+func parseDirectives(sql string) (d directives, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(sql))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		match := regDirective.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		switch match[1] {
+		case directiveNoTransaction:
+			d.noTransaction = true
+		case directiveTemplate:
+			d.template = true
+		case directiveStatementTimeout:
+			if d.statementTimeout, err = parseTimeout(match[1], match[2]); err != nil {
+				return directives{}, err
+			}
+		case directiveLockTimeout:
+			if d.lockTimeout, err = parseTimeout(match[1], match[2]); err != nil {
+				return directives{}, err
+			}
+		case directiveStatementBegin, directiveStatementEnd:
+			// Маркеры запросов разбирает splitStatements, на них заголовок заканчивается.
+			return d, nil
+		default:
+			return directives{}, fmt.Errorf("%w: %s", ErrUnknownDirective, match[1])
+		}
+	}
+
+	return d, scanner.Err()
+}
+
+// parseTimeout разбирает длительность вида 30s или 1m30s. 0 возвращает значение по умолчанию базы.
+func parseTimeout(directive, value string) (*time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return nil, fmt.Errorf("%w: %s %q", ErrInvalidDirectiveValue, directive, value)
+	}
+
+	return &timeout, nil
+}
+
+// timeouts возвращает ограничения мигратора с учётом директив миграции.
+func (d directives) timeouts(defaults storage.Timeouts) storage.Timeouts {
+	if d.statementTimeout != nil {
+		defaults.Statement = *d.statementTimeout
+	}
+
+	if d.lockTimeout != nil {
+		defaults.Lock = *d.lockTimeout
+	}
+
+	return defaults
+}
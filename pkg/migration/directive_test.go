@@ -0,0 +1,51 @@
+package migration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDirectives(t *testing.T) {
+	t.Run("no transaction", func(t *testing.T) {
+		d, err := parseDirectives("-- index without lock\n-- +migrate NoTransaction\n\nCREATE INDEX CONCURRENTLY idx ON t (id);")
+		require.Nil(t, err)
+		require.True(t, d.noTransaction)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		d, err := parseDirectives("-- +migrate Template\nGRANT SELECT ON t TO {{ .role }};")
+		require.Nil(t, err)
+		require.True(t, d.template)
+		require.False(t, d.noTransaction)
+	})
+
+	t.Run("directive after statement is ignored", func(t *testing.T) {
+		d, err := parseDirectives("SELECT 1;\n-- +migrate NoTransaction\n")
+		require.Nil(t, err)
+		require.False(t, d.noTransaction)
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		d, err := parseDirectives("-- +migrate StatementTimeout 30s\n-- +migrate LockTimeout 1m30s\nALTER TABLE t ADD c INT;")
+		require.Nil(t, err)
+		require.Equal(t, storage.Timeouts{Statement: 30 * time.Second, Lock: 90 * time.Second},
+			d.timeouts(storage.Timeouts{Statement: time.Hour, Lock: time.Hour}))
+
+		d, err = parseDirectives("-- +migrate LockTimeout 2s\n")
+		require.Nil(t, err)
+		require.Equal(t, storage.Timeouts{Statement: time.Hour, Lock: 2 * time.Second},
+			d.timeouts(storage.Timeouts{Statement: time.Hour}))
+
+		_, err = parseDirectives("-- +migrate LockTimeout soon\n")
+		require.ErrorIs(t, err, ErrInvalidDirectiveValue)
+	})
+
+	t.Run("unknown directive", func(t *testing.T) {
+		_, err := parseDirectives("-- +migrate Unknown\nSELECT 1;")
+		require.ErrorIs(t, err, ErrUnknownDirective)
+	})
+}
@@ -0,0 +1,34 @@
+package migration
+
+import (
+	"errors"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+// newFailure собирает подробности ошибки миграции. Для SQL-миграций в неё попадают файл и строка,
+// с которой начинается упавший запрос; Position считается от начала этого запроса.
+func newFailure(err error) entity.Failure {
+	failure := entity.Failure{
+		Message: err.Error(),
+	}
+
+	var stmtErr *StatementError
+	if errors.As(err, &stmtErr) {
+		failure.File = stmtErr.File
+		failure.Line = stmtErr.Line
+		failure.Message = stmtErr.Err.Error()
+	}
+
+	var dbErr *storage.DBError
+	if errors.As(err, &dbErr) {
+		failure.Code = dbErr.Code
+		failure.Message = dbErr.Message
+		failure.Detail = dbErr.Detail
+		failure.Hint = dbErr.Hint
+		failure.Position = dbErr.Position
+	}
+
+	return failure
+}
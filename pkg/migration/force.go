@@ -0,0 +1,93 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+// Статусы, которые можно выставить командой force.
+const (
+	ForceApplied    = "applied"
+	ForceRolledBack = "rolled-back"
+)
+
+var (
+	ErrForce              = errors.New("error force")
+	ErrUnknownForceStatus = errors.New("unknown force status")
+)
+
+// Force вручную выставляет миграции статус "применена" или "отменена", не выполняя её SQL.
+// Нужен, чтобы снять ErrDirty после того, как оператор проверил состояние схемы.
+func (m *migrator) Force(ctx context.Context, version int64, status string) ([]StatusRecord, error) {
+	m.logger.Info(fmt.Sprintf("Force migration %d to %s start", version, status))
+
+	var endStatus string
+
+	switch status {
+	case ForceApplied:
+		endStatus = storage.StatusSuccess
+	case ForceRolledBack:
+		endStatus = storage.StatusCancel
+	default:
+		return nil, m.logError(ErrForce, fmt.Errorf("%w: %s", ErrUnknownForceStatus, status))
+	}
+
+	err := m.withLock(ctx, ErrForce, func() error {
+		forced, err := m.forcedMigration(ctx, version)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		forced.SetStatus(endStatus)
+		forced.SetStatusChangeTime(now)
+		forced.SetDuration(0)
+		forced.SetFailure(entity.Failure{})
+
+		if endStatus == storage.StatusSuccess {
+			forced.SetAppliedTime(now)
+		} else {
+			forced.SetAppliedTime(time.Time{})
+		}
+
+		if err = m.saveStatus(ctx, m.storage, forced, nil); err != nil {
+			return err
+		}
+
+		m.processed = append(m.processed, newStatusRecord(forced))
+		return nil
+	})
+	if err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info(fmt.Sprintf("Force migration %d to %s end", version, status))
+	return m.processed, nil
+}
+
+// forcedMigration возвращает миграцию для force: локальную, если она есть, иначе запись из базы,
+// например для миграции, файл которой уже удалён.
+func (m *migrator) forcedMigration(ctx context.Context, version int64) (entity.Migration, error) {
+	if local := m.find(version); local != nil {
+		return local, nil
+	}
+
+	migrations, err := m.storage.SelectMigrations(ctx)
+	if err != nil && err != storage.ErrMigrationNotFound {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if migration.GetVersion() == version {
+			return migration, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrUnknownTargetVersion, version)
+}
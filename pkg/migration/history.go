@@ -0,0 +1,139 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"runtime/debug"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+const modulePath = "github.com/MyLi2tlePony/sql-migrator"
+
+var ErrGetHistory = errors.New("error db history")
+
+// origin описывает, кто и откуда применяет миграции. Записывается в журнал статусов.
+type origin struct {
+	hostname    string
+	user        string
+	toolVersion string
+}
+
+func newOrigin() origin {
+	o := origin{
+		toolVersion: toolVersion(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		o.hostname = hostname
+	}
+
+	if current, err := user.Current(); err == nil {
+		o.user = current.Username
+	} else {
+		o.user = os.Getenv("USER")
+	}
+
+	return o
+}
+
+// toolVersion берёт версию модуля мигратора из информации о сборке: это сам бинарник
+// gomigrator или зависимость сервиса, который использует библиотеку.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return ""
+}
+
+// saveStatus сохраняет статус миграции и добавляет переход в журнал.
+func (m *migrator) saveStatus(ctx context.Context, db storage.Storage, migration entity.Migration, cause error) error {
+	if err := db.InsertMigration(ctx, migration); err != nil {
+		return err
+	}
+
+	return db.InsertHistory(ctx, m.newHistory(migration, cause))
+}
+
+// keepApplied сохраняет ошибку отката, который транзакция отменила целиком: схема осталась
+// в применённой версии, поэтому запись миграции остаётся прежней, кроме подробностей ошибки,
+// а ошибка попадает в журнал.
+func (m *migrator) keepApplied(ctx context.Context, migration entity.Migration, cause error) error {
+	migrations, err := m.storage.SelectMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, stored := range migrations {
+		if stored.GetVersion() != migration.GetVersion() {
+			continue
+		}
+
+		migration.SetStatus(stored.GetStatus())
+		migration.SetStatusChangeTime(stored.GetStatusChangeTime())
+		migration.SetAppliedTime(stored.GetAppliedTime())
+		migration.SetDuration(stored.GetDuration())
+		migration.SetChecksum(stored.GetChecksum())
+	}
+
+	migration.SetFailure(newFailure(cause))
+
+	if err = m.storage.InsertMigration(ctx, migration); err != nil {
+		return err
+	}
+
+	history := m.newHistory(migration, cause)
+	history.Status = storage.StatusError
+	history.Time = time.Now()
+	history.Duration = 0
+
+	return m.storage.InsertHistory(ctx, history)
+}
+
+func (m *migrator) newHistory(migration entity.Migration, cause error) entity.History {
+	history := entity.History{
+		Version:     migration.GetVersion(),
+		Name:        migration.GetName(),
+		Status:      migration.GetStatus(),
+		Time:        migration.GetStatusChangeTime(),
+		Hostname:    m.origin.hostname,
+		User:        m.origin.user,
+		ToolVersion: m.origin.toolVersion,
+	}
+
+	if migration.GetStatus() == storage.StatusSuccess || migration.GetStatus() == storage.StatusCancel {
+		history.Duration = migration.GetDuration()
+	}
+
+	if cause != nil {
+		history.Error = cause.Error()
+	}
+
+	return history
+}
+
+// History возвращает журнал переходов статусов, version 0 — по всем миграциям.
+func (m *migrator) History(ctx context.Context, version int64) ([]entity.History, error) {
+	history, err := m.storage.SelectHistory(ctx, version)
+	if err != nil {
+		return nil, m.logError(ErrGetHistory, err)
+	}
+
+	return history, nil
+}
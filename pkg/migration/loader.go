@@ -0,0 +1,165 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+)
+
+// SQLMigration — SQL-миграция, прочитанная из файлов.
+type SQLMigration struct {
+	Version int64
+	Name    string
+
+	Up   string
+	Down string
+
+	UpSource   Source
+	DownSource Source
+}
+
+// RepeatableVersion — версия повторяемых миграций: они применяются не по номеру,
+// а при каждом изменении содержимого.
+const RepeatableVersion = storage.RepeatableVersion
+
+// Source указывает на файл секции и строку, с которой секция в нём начинается.
+// По нему позиция ошибки из базы переводится в строку файла.
+type Source struct {
+	File string
+	Line int
+}
+
+var (
+	ErrInvalidMigrationName = errors.New("invalid migration name")
+	ErrDuplicateVersion     = errors.New("duplicate migration version")
+
+	regMigrationVersion = regexp.MustCompile(`^\d+`)
+	regSQLMigration     = regexp.MustCompile(`^(\d+)_(.+?)(?:_(up|down))?\.sql$`)
+	regGoMigration      = regexp.MustCompile(`^.+\.go$`)
+
+	regRepeatableMigration = regexp.MustCompile(`^R__(.+)\.sql$`)
+)
+
+// Load читает SQL-миграции из корня fsys: пары NNNNN_name_up.sql и NNNNN_name_down.sql
+// или однофайловые NNNNN_name.sql с секциями "-- +up" и "-- +down". Go-файлы и файлы
+// без номера версии пропускаются. Миграции возвращаются в порядке возрастания версий.
+//
+// Повторяемые миграции R__name.sql целиком состоят из секции up и возвращаются после
+// версионных в порядке имён с версией RepeatableVersion.
+//
+// Подходит любая fs.FS: os.DirFS, embed.FS, fstest.MapFS или fs.Sub для подкаталога.
+func Load(fsys fs.FS) ([]SQLMigration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[int64]*SQLMigration)
+	// files хранит имена файлов по версии и секции, first — первый файл версии.
+	files := make(map[int64]map[string]string)
+	first := make(map[int64]string)
+	repeatable := make([]SQLMigration, 0)
+
+	for _, file := range entries {
+		if file.IsDir() || regGoMigration.MatchString(file.Name()) {
+			continue
+		}
+
+		if parts := regRepeatableMigration.FindStringSubmatch(file.Name()); parts != nil {
+			sql, err := fs.ReadFile(fsys, file.Name())
+			if err != nil {
+				return nil, err
+			}
+
+			repeatable = append(repeatable, SQLMigration{
+				Version:  RepeatableVersion,
+				Name:     parts[1],
+				Up:       string(sql),
+				UpSource: Source{File: file.Name(), Line: 1},
+			})
+
+			continue
+		}
+
+		if regMigrationVersion.FindString(file.Name()) == "" {
+			continue
+		}
+
+		parts := regSQLMigration.FindStringSubmatch(file.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationName, file.Name())
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		sql, err := fs.ReadFile(fsys, file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := migrations[version]
+		if !ok {
+			m = &SQLMigration{
+				Version: version,
+				Name:    parts[2],
+			}
+			migrations[version] = m
+			files[version] = make(map[string]string)
+			first[version] = file.Name()
+		}
+
+		sections := []string{parts[3]}
+		if parts[3] == "" {
+			sections = []string{sectionUp, sectionDown}
+		}
+
+		for _, section := range sections {
+			previous, ok := files[version][section]
+			if !ok && m.Name != parts[2] {
+				previous, ok = first[version], true
+			}
+
+			if ok {
+				return nil, fmt.Errorf("%w %d: %s and %s", ErrDuplicateVersion, version, previous, file.Name())
+			}
+
+			files[version][section] = file.Name()
+		}
+
+		switch parts[3] {
+		case sectionUp:
+			m.Up = string(sql)
+			m.UpSource = Source{File: file.Name(), Line: 1}
+		case sectionDown:
+			m.Down = string(sql)
+			m.DownSource = Source{File: file.Name(), Line: 1}
+		default:
+			if m.Up, m.Down, err = parseSQLMigration(file.Name(), string(sql)); err != nil {
+				return nil, err
+			}
+
+			m.UpSource = Source{File: file.Name(), Line: sectionLine(string(sql), sectionUp)}
+			m.DownSource = Source{File: file.Name(), Line: sectionLine(string(sql), sectionDown)}
+		}
+	}
+
+	sorted := make([]SQLMigration, 0, len(migrations))
+	for _, m := range migrations {
+		sorted = append(sorted, *m)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	// fs.ReadDir возвращает файлы по именам, поэтому повторяемые миграции уже упорядочены.
+	return append(sorted, repeatable...), nil
+}
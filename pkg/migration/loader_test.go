@@ -0,0 +1,82 @@
+package migration
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/00002_users.sql":      {Data: []byte("-- +up\nCREATE TABLE users (id INTEGER);\n-- +down\nDROP TABLE users;\n")},
+		"db/00001_init_up.sql":    {Data: []byte("CREATE SCHEMA app;")},
+		"db/00001_init_down.sql":  {Data: []byte("DROP SCHEMA app;")},
+		"db/00003_backfill.go":    {Data: []byte("package migrations")},
+		"db/README.md":            {Data: []byte("docs")},
+		"db/00004_nested/foo.sql": {Data: []byte("SELECT 1;")},
+		"db/R__views.sql":         {Data: []byte("CREATE OR REPLACE VIEW v AS SELECT 1;")},
+		"db/R__grants.sql":        {Data: []byte("GRANT SELECT ON v TO app;")},
+	}
+
+	sub, err := fs.Sub(fsys, "db")
+	require.Nil(t, err)
+
+	migrations, err := Load(sub)
+	require.Nil(t, err)
+	require.Equal(t, []SQLMigration{
+		{
+			Version: 1, Name: "init", Up: "CREATE SCHEMA app;", Down: "DROP SCHEMA app;",
+			UpSource:   Source{File: "00001_init_up.sql", Line: 1},
+			DownSource: Source{File: "00001_init_down.sql", Line: 1},
+		},
+		{
+			Version: 2, Name: "users", Up: "CREATE TABLE users (id INTEGER);\n", Down: "DROP TABLE users;\n",
+			UpSource:   Source{File: "00002_users.sql", Line: 2},
+			DownSource: Source{File: "00002_users.sql", Line: 4},
+		},
+		{
+			Version: RepeatableVersion, Name: "grants", Up: "GRANT SELECT ON v TO app;",
+			UpSource: Source{File: "R__grants.sql", Line: 1},
+		},
+		{
+			Version: RepeatableVersion, Name: "views", Up: "CREATE OR REPLACE VIEW v AS SELECT 1;",
+			UpSource: Source{File: "R__views.sql", Line: 1},
+		},
+	}, migrations)
+
+	fsys["db/00005-bad.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	_, err = Load(sub)
+	require.ErrorIs(t, err, ErrInvalidMigrationName)
+
+	delete(fsys, "db/00005-bad.sql")
+	fsys["db/00005_broken.sql"] = &fstest.MapFile{Data: []byte("-- +up\nSELECT 1;\n")}
+
+	_, err = Load(sub)
+	require.ErrorIs(t, err, ErrMissingMarker)
+
+	fsys["db/00005_broken.sql"] = &fstest.MapFile{
+		Data: []byte("-- +migrate NoTransaction\n-- +up\nCREATE INDEX CONCURRENTLY idx ON users (id);\n-- +down\nDROP INDEX idx;\n"),
+	}
+
+	_, err = Load(sub)
+	require.ErrorIs(t, err, ErrDirectiveOutside)
+
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.Equal(t, "00005_broken.sql", parseErr.File)
+	require.Equal(t, 1, parseErr.Line)
+
+	delete(fsys, "db/00005_broken.sql")
+
+	for _, name := range []string{"db/00002_other.sql", "db/00001_init.sql", "db/00001_other_down.sql"} {
+		fsys[name] = &fstest.MapFile{Data: []byte("-- +up\n-- +down\n")}
+
+		_, err = Load(sub)
+		require.ErrorIs(t, err, ErrDuplicateVersion, name)
+
+		delete(fsys, name)
+	}
+}
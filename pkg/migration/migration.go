@@ -1,18 +1,32 @@
 package migration
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
 )
 
 type migration struct {
-	version int
+	version int64
 	name    string
 
 	up   string
 	down string
 
+	upSource   Source
+	downSource Source
+
+	upFunc   MigrateFunc
+	downFunc MigrateFunc
+
 	status           string
 	statusChangeTime time.Time
+	appliedTime      time.Time
+	duration         time.Duration
+	checksum         string
+	failure          entity.Failure
 }
 
 func (m *migration) GetName() string {
@@ -23,7 +37,7 @@ func (m *migration) GetStatus() string {
 	return m.status
 }
 
-func (m *migration) GetVersion() int {
+func (m *migration) GetVersion() int64 {
 	return m.version
 }
 
@@ -31,6 +45,22 @@ func (m *migration) GetStatusChangeTime() time.Time {
 	return m.statusChangeTime
 }
 
+func (m *migration) GetAppliedTime() time.Time {
+	return m.appliedTime
+}
+
+func (m *migration) GetDuration() time.Duration {
+	return m.duration
+}
+
+func (m *migration) GetChecksum() string {
+	return m.checksum
+}
+
+func (m *migration) GetFailure() entity.Failure {
+	return m.failure
+}
+
 func (m *migration) SetName(name string) {
 	m.name = name
 }
@@ -39,10 +69,35 @@ func (m *migration) SetStatus(status string) {
 	m.status = status
 }
 
-func (m *migration) SetVersion(version int) {
+func (m *migration) SetVersion(version int64) {
 	m.version = version
 }
 
 func (m *migration) SetStatusChangeTime(statusChangeTime time.Time) {
 	m.statusChangeTime = statusChangeTime
 }
+
+func (m *migration) SetAppliedTime(appliedTime time.Time) {
+	m.appliedTime = appliedTime
+}
+
+func (m *migration) SetDuration(duration time.Duration) {
+	m.duration = duration
+}
+
+func (m *migration) SetChecksum(checksum string) {
+	m.checksum = checksum
+}
+
+func (m *migration) SetFailure(failure entity.Failure) {
+	m.failure = failure
+}
+
+func checksum(up, down string) string {
+	hash := sha256.New()
+	hash.Write([]byte(up))
+	hash.Write([]byte{0})
+	hash.Write([]byte(down))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
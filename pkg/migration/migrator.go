@@ -4,21 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
 	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
-	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/postgres"
 )
 
 type Migration interface {
 	Connect(context.Context) error
+	// ConnectReadOnly подключается без создания и обновления служебных таблиц, например для Plan.
+	ConnectReadOnly(context.Context) error
 	Close(context.Context) error
-	Create(name, up, down string)
-	Up(context.Context) error
-	Down(context.Context) error
-	Redo(context.Context) error
-	Status(context.Context) error
-	DbVersion(context.Context) error
+	Create(version int64, name, up, down string)
+	Add(SQLMigration)
+	Up(context.Context) ([]StatusRecord, error)
+	UpTo(context.Context, int64) ([]StatusRecord, error)
+	Down(context.Context) ([]StatusRecord, error)
+	DownTo(context.Context, int64) ([]StatusRecord, error)
+	Redo(context.Context) ([]StatusRecord, error)
+	Status(context.Context) ([]StatusRecord, error)
+	DbVersion(context.Context) (int64, error)
+	Validate(context.Context) ([]StatusRecord, error)
+	Repair(context.Context) ([]StatusRecord, error)
+	Plan(context.Context, Action, int64) ([]PlanStep, error)
+	History(context.Context, int64) ([]entity.History, error)
+	Force(ctx context.Context, version int64, status string) ([]StatusRecord, error)
+	Baseline(ctx context.Context, version int64) ([]StatusRecord, error)
+	ConvertVersions(context.Context) ([]StatusRecord, error)
 }
 
 type Logger interface {
@@ -26,13 +42,38 @@ type Logger interface {
 	Info(string)
 }
 
+type Option func(*migrator)
+
 type migrator struct {
 	logger Logger
 
-	storage    postgres.Storage
+	storage    storage.Storage
 	migrations []migration
+	// repeatable — повторяемые миграции в порядке имён, применяются после версионных.
+	repeatable []migration
+
+	lockTimeout      time.Duration
+	lockPollInterval time.Duration
+
+	allowOutOfOrder bool
+
+	timeouts         storage.Timeouts
+	lockRetries      int
+	lockRetryBackoff time.Duration
+
+	origin origin
+
+	storageOptions []storage.Option
+
+	processed []StatusRecord
 }
 
+const (
+	DefaultLockTimeout      = time.Minute
+	DefaultLockRetryBackoff = time.Second
+	defaultLockPollInterval = 500 * time.Millisecond
+)
+
 var (
 	ErrConnect       = errors.New("error connect")
 	ErrClose         = errors.New("error close")
@@ -41,30 +82,138 @@ var (
 	ErrMigrationRedo = errors.New("error migration redo")
 	ErrGetStatus     = errors.New("error db status")
 	ErrGetVersion    = errors.New("error db version")
+	ErrLock          = errors.New("error lock")
+	ErrUnlock        = errors.New("error unlock")
+	ErrLockTimeout   = errors.New("lock timeout")
+	ErrValidate      = errors.New("error validate")
+	ErrRepair        = errors.New("error repair")
+
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 
 	ErrUnexpectedMigrationVersion = errors.New("unexpected migration version")
+	ErrUnknownTargetVersion       = errors.New("unknown target version")
+	ErrTargetVersionApplied       = errors.New("target version is below the current version")
+	ErrTargetVersionAhead         = errors.New("target version is above the current version")
+	ErrOutOfOrder                 = errors.New("pending migrations below the current version")
+	ErrDirty                      = errors.New("migrations left unfinished")
 )
 
-func New(connString string, logger Logger) Migration {
-	return &migrator{
-		storage:    postgres.New(connString),
-		logger:     logger,
-		migrations: make([]migration, 0),
+// Error связывает ошибку с операцией мигратора, на которой она произошла,
+// чтобы вызывающий код мог различать их через errors.Is.
+type Error struct {
+	Op  error
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Op.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Op == target
+}
+
+// New создаёт мигратор, выбирая драйвер хранилища по схеме строки подключения.
+// Драйвер должен быть зарегистрирован, например импортом пакета pkg/storage/postgres.
+func New(connString string, logger Logger, opts ...Option) (Migration, error) {
+	m := &migrator{
+		logger:           logger,
+		migrations:       registered(),
+		lockTimeout:      DefaultLockTimeout,
+		lockPollInterval: defaultLockPollInterval,
+		lockRetryBackoff: DefaultLockRetryBackoff,
+		origin:           newOrigin(),
 	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	var err error
+	if m.storage, err = storage.Open(connString, m.storageOptions...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
-func (m *migrator) logError(method, err error) {
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+// WithTimeouts задаёт statement_timeout и lock_timeout, которые выставляются перед каждой миграцией.
+// Директивы "-- +migrate StatementTimeout 30s" и "-- +migrate LockTimeout 5s" в заголовке
+// SQL-миграции перекрывают их для отдельного файла.
+func WithTimeouts(statement, lock time.Duration) Option {
+	return func(m *migrator) {
+		m.timeouts = storage.Timeouts{Statement: statement, Lock: lock}
+	}
+}
+
+// WithLockRetries повторяет миграцию, не дождавшуюся блокировки за lock_timeout, до retries раз.
+// Пауза перед первым повтором равна backoff и удваивается с каждой попыткой.
+func WithLockRetries(retries int, backoff time.Duration) Option {
+	return func(m *migrator) {
+		m.lockRetries = retries
+
+		if backoff > 0 {
+			m.lockRetryBackoff = backoff
+		}
+	}
+}
+
+// WithAllowOutOfOrder разрешает применять миграции с версией ниже последней применённой,
+// например появившиеся после слияния веток. Без опции up завершается с ErrOutOfOrder.
+func WithAllowOutOfOrder(allow bool) Option {
+	return func(m *migrator) {
+		m.allowOutOfOrder = allow
+	}
+}
+
+func WithSchema(schema string) Option {
+	return func(m *migrator) {
+		m.storageOptions = append(m.storageOptions, storage.WithSchema(schema))
+	}
+}
+
+func WithTable(table string) Option {
+	return func(m *migrator) {
+		m.storageOptions = append(m.storageOptions, storage.WithTable(table))
+	}
+}
+
+func (m *migrator) logError(method, err error) error {
 	m.logger.Error(method.Error())
 	m.logger.Error(err.Error())
+
+	return &Error{Op: method, Err: err}
 }
 
 func (m *migrator) Connect(ctx context.Context) error {
+	if err := m.ConnectReadOnly(ctx); err != nil {
+		return err
+	}
+
+	if err := m.storage.Init(ctx); err != nil {
+		_ = m.storage.Close(ctx)
+		return m.logError(ErrConnect, err)
+	}
+
+	return nil
+}
+
+func (m *migrator) ConnectReadOnly(ctx context.Context) error {
 	m.logger.Info("Db connect")
 
 	err := m.storage.Connect(ctx)
 	if err != nil {
-		m.logError(ErrConnect, err)
-		return err
+		return m.logError(ErrConnect, err)
 	}
 
 	return nil
@@ -75,200 +224,603 @@ func (m *migrator) Close(ctx context.Context) error {
 
 	err := m.storage.Close(ctx)
 	if err != nil {
-		m.logError(ErrClose, err)
+		return m.logError(ErrClose, err)
+	}
+
+	return nil
+}
+
+func (m *migrator) lock(ctx context.Context) error {
+	locked, err := m.storage.TryLock(ctx)
+	if err != nil {
 		return err
 	}
 
+	if locked {
+		return nil
+	}
+
+	deadline := time.NewTimer(m.lockTimeout)
+	defer deadline.Stop()
+
+	poll := time.NewTicker(m.lockPollInterval)
+	defer poll.Stop()
+
+	holder := 0
+
+	for {
+		pid, err := m.storage.LockHolder(ctx)
+		if err != nil && !errors.Is(err, storage.ErrLockHolderUnknown) {
+			return err
+		}
+
+		if pid != 0 && pid != holder {
+			holder = pid
+			m.logger.Info(fmt.Sprintf("waiting for lock held by pid %d", pid))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return ErrLockTimeout
+		case <-poll.C:
+		}
+
+		locked, err = m.storage.TryLock(ctx)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			return nil
+		}
+	}
+}
+
+func (m *migrator) unlock(ctx context.Context) error {
+	return m.storage.Unlock(ctx)
+}
+
+func (m *migrator) withLock(ctx context.Context, method error, fn func() error) (err error) {
+	m.processed = make([]StatusRecord, 0)
+
+	if err = m.lock(ctx); err != nil {
+		return m.logError(method, &Error{Op: ErrLock, Err: err})
+	}
+
+	defer func() {
+		if errUnlock := m.unlock(ctx); errUnlock != nil {
+			errUnlock = m.logError(method, &Error{Op: ErrUnlock, Err: errUnlock})
+
+			if err == nil {
+				err = errUnlock
+			}
+		}
+	}()
+
+	if err = fn(); err != nil {
+		return m.logError(method, err)
+	}
+
 	return nil
 }
 
-func (m *migrator) Create(name, up, down string) {
-	m.migrations = append(m.migrations, migration{
-		version: len(m.migrations) + 1,
-		name:    name,
-		up:      up,
-		down:    down,
-	})
+func (m *migrator) Create(version int64, name, up, down string) {
+	m.Add(SQLMigration{Version: version, Name: name, Up: up, Down: down})
 }
 
-func (m *migrator) Up(ctx context.Context) (err error) {
-	m.logger.Info("Up migrations start")
+// Add добавляет миграцию, прочитанную Load. В отличие от Create сохраняет файлы секций,
+// чтобы ошибку из базы можно было привязать к строке файла.
+func (m *migrator) Add(sqlMigration SQLMigration) {
+	migration := migration{
+		version:    sqlMigration.Version,
+		name:       sqlMigration.Name,
+		up:         sqlMigration.Up,
+		down:       sqlMigration.Down,
+		upSource:   sqlMigration.UpSource,
+		downSource: sqlMigration.DownSource,
+		checksum:   checksum(sqlMigration.Up, sqlMigration.Down),
+	}
 
-	lastVersion := 0
+	if migration.version == RepeatableVersion {
+		m.repeatable = append(m.repeatable, migration)
 
-	lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, postgres.StatusSuccess)
-	if err == nil {
-		lastVersion = lastMigration.GetVersion()
-	} else if err != postgres.ErrMigrationNotFound {
-		m.logError(ErrMigrationUp, err)
-		return err
+		sort.SliceStable(m.repeatable, func(i, j int) bool {
+			return m.repeatable[i].name < m.repeatable[j].name
+		})
+
+		return
 	}
 
-	if lastMigration != nil && lastMigration.GetVersion()-1 > len(m.migrations) {
-		m.logError(ErrMigrationUp, ErrUnexpectedMigrationVersion)
-		return ErrUnexpectedMigrationVersion
+	m.migrations = append(m.migrations, migration)
+
+	sort.SliceStable(m.migrations, func(i, j int) bool {
+		return m.migrations[i].version < m.migrations[j].version
+	})
+}
+
+func (m *migrator) find(version int64) *migration {
+	for i := range m.migrations {
+		if m.migrations[i].version == version {
+			return &m.migrations[i]
+		}
 	}
 
-	for i := lastVersion; i < len(m.migrations); i++ {
-		err = m.upMigration(ctx, &m.migrations[i], m.migrations[i].up)
-		if err != nil {
-			m.logError(ErrMigrationUp, err)
-			return err
+	return nil
+}
+
+// lastVersion возвращает последнюю применённую версию с учётом базовых миграций.
+func (m *migrator) lastVersion(ctx context.Context) (int64, error) {
+	last := int64(0)
+
+	for _, status := range []string{storage.StatusSuccess, storage.StatusBaseline} {
+		lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, status)
+		if err == storage.ErrMigrationNotFound {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if lastMigration.GetVersion() > last {
+			last = lastMigration.GetVersion()
 		}
 	}
 
+	return last, nil
+}
+
+func (m *migrator) Up(ctx context.Context) ([]StatusRecord, error) {
+	m.logger.Info("Up migrations start")
+
+	if err := m.withLock(ctx, ErrMigrationUp, func() error { return m.up(ctx, math.MaxInt64) }); err != nil {
+		return m.processed, err
+	}
+
 	m.logger.Info("Up migrations end")
-	return nil
+	return m.processed, nil
 }
 
-func (m *migrator) upMigration(ctx context.Context, migration entity.Migration, sql string) (err error) {
-	migration.SetStatus(postgres.StatusProcess)
-	migration.SetStatusChangeTime(time.Now())
+func (m *migrator) UpTo(ctx context.Context, version int64) ([]StatusRecord, error) {
+	m.logger.Info(fmt.Sprintf("Up migrations to version %d start", version))
 
-	if err = m.storage.InsertMigration(ctx, migration); err != nil {
-		return err
+	if m.find(version) == nil {
+		return nil, m.logError(ErrMigrationUp, ErrUnknownTargetVersion)
 	}
 
-	if err = m.storage.Migrate(ctx, sql); err != nil {
-		migration.SetStatus(postgres.StatusError)
-		migration.SetStatusChangeTime(time.Now())
+	if err := m.withLock(ctx, ErrMigrationUp, func() error { return m.up(ctx, version) }); err != nil {
+		return m.processed, err
+	}
 
-		if errStatus := m.storage.InsertMigration(ctx, migration); errStatus != nil {
-			return errStatus
-		}
+	m.logger.Info(fmt.Sprintf("Up migrations to version %d end", version))
+	return m.processed, nil
+}
 
+// up применяет миграции до версии target, а если target не ограничен, то и изменившиеся
+// повторяемые миграции.
+func (m *migrator) up(ctx context.Context, target int64) error {
+	pending, err := m.pendingMigrations(ctx, target)
+	if err != nil {
 		return err
 	}
 
-	migration.SetStatus(postgres.StatusSuccess)
-	migration.SetStatusChangeTime(time.Now())
+	if target == math.MaxInt64 {
+		repeatable, err := m.changedRepeatable(ctx)
+		if err != nil {
+			return err
+		}
 
-	if err = m.storage.InsertMigration(ctx, migration); err != nil {
-		return err
+		pending = append(pending, repeatable...)
+	}
+
+	for _, migration := range pending {
+		if err = m.upMigration(ctx, migration); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (m *migrator) Down(ctx context.Context) (err error) {
+func (m *migrator) upMigration(ctx context.Context, migration *migration) error {
+	if migration.version == RepeatableVersion {
+		m.logger.Info(fmt.Sprintf("Up repeatable migration %s", migration.name))
+	} else {
+		m.logger.Info(fmt.Sprintf("Up migration %d %s", migration.version, migration.name))
+	}
+
+	err := m.migrate(ctx, migration, migration.up, migration.upSource, migration.upFunc,
+		storage.StatusProcess, storage.StatusSuccess)
+	m.processed = append(m.processed, newStatusRecord(migration))
+
+	return err
+}
+
+func (m *migrator) Down(ctx context.Context) ([]StatusRecord, error) {
 	m.logger.Info("Down migration start")
 
-	lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, postgres.StatusSuccess)
+	if err := m.withLock(ctx, ErrMigrationDown, func() error { return m.down(ctx) }); err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info("Down migration end")
+	return m.processed, nil
+}
+
+func (m *migrator) DownTo(ctx context.Context, version int64) ([]StatusRecord, error) {
+	m.logger.Info(fmt.Sprintf("Down migrations to version %d start", version))
+
+	if version != 0 && m.find(version) == nil {
+		return nil, m.logError(ErrMigrationDown, ErrUnknownTargetVersion)
+	}
+
+	if err := m.withLock(ctx, ErrMigrationDown, func() error { return m.downTo(ctx, version) }); err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info(fmt.Sprintf("Down migrations to version %d end", version))
+	return m.processed, nil
+}
+
+func (m *migrator) down(ctx context.Context) error {
+	last, err := m.lastApplied(ctx)
 	if err != nil {
-		m.logError(ErrMigrationDown, err)
 		return err
 	}
 
-	if lastMigration != nil && lastMigration.GetVersion()-1 > len(m.migrations) {
-		m.logError(ErrMigrationDown, ErrUnexpectedMigrationVersion)
-		return ErrUnexpectedMigrationVersion
-	}
+	return m.downMigration(ctx, last[0])
+}
 
-	downMigrationIndex := lastMigration.GetVersion() - 1
-	err = m.downMigration(ctx, &m.migrations[downMigrationIndex], m.migrations[downMigrationIndex].down)
+func (m *migrator) downTo(ctx context.Context, target int64) error {
+	rollback, err := m.rollbackMigrations(ctx, target)
 	if err != nil {
-		m.logError(ErrMigrationDown, err)
 		return err
 	}
 
-	m.logger.Info("Down migration end")
+	for _, migration := range rollback {
+		if err = m.downMigration(ctx, migration); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (m *migrator) downMigration(ctx context.Context, migration entity.Migration, sql string) (err error) {
-	migration.SetStatus(postgres.StatusCancellation)
-	migration.SetStatusChangeTime(time.Now())
+func (m *migrator) downMigration(ctx context.Context, migration *migration) error {
+	m.logger.Info(fmt.Sprintf("Down migration %d %s", migration.version, migration.name))
 
-	if err = m.storage.InsertMigration(ctx, migration); err != nil {
-		return err
+	err := m.migrate(ctx, migration, migration.down, migration.downSource, migration.downFunc,
+		storage.StatusCancellation, storage.StatusCancel)
+	m.processed = append(m.processed, newStatusRecord(migration))
+
+	return err
+}
+
+func (m *migrator) migrate(ctx context.Context, migration entity.Migration, sql string, source Source,
+	fn MigrateFunc, startStatus, endStatus string,
+) (err error) {
+	var d directives
+
+	if fn == nil {
+		if d, err = parseDirectives(sql); err != nil {
+			return err
+		}
+
+		statements, errSplit := splitStatements(sql)
+		if errSplit != nil {
+			return errSplit
+		}
+
+		fn = m.execStatements(statements, source)
 	}
 
-	if err = m.storage.Migrate(ctx, sql); err != nil {
-		migration.SetStatus(postgres.StatusError)
-		migration.SetStatusChangeTime(time.Now())
+	timeouts := d.timeouts(m.timeouts)
 
-		if errStatus := m.storage.InsertMigration(ctx, migration); errStatus != nil {
+	for attempt := 0; ; attempt++ {
+		if d.noTransaction {
+			err = m.execMigration(ctx, m.storage, migration, fn, timeouts, true, startStatus, endStatus)
+		} else {
+			err = m.storage.Transaction(ctx, func(tx storage.Storage) error {
+				return m.execMigration(ctx, tx, migration, fn, timeouts, false, startStatus, endStatus)
+			})
+		}
+
+		// Повторять можно только откаченную транзакцию: без неё часть запросов уже выполнена.
+		if err == nil || d.noTransaction || attempt >= m.lockRetries || !storage.IsLockTimeout(err) {
+			break
+		}
+
+		if m.waitLockRetry(ctx, migration, attempt) != nil {
+			break
+		}
+	}
+
+	if err != nil && !d.noTransaction && startStatus == storage.StatusCancellation {
+		if errStatus := m.keepApplied(ctx, migration, err); errStatus != nil {
 			return errStatus
 		}
 
 		return err
 	}
 
-	migration.SetStatus(postgres.StatusCancel)
-	migration.SetStatusChangeTime(time.Now())
+	if err != nil {
+		migration.SetStatus(storage.StatusError)
+		migration.SetStatusChangeTime(time.Now())
+		migration.SetFailure(newFailure(err))
+
+		if errStatus := m.saveStatus(ctx, m.storage, migration, err); errStatus != nil {
+			return errStatus
+		}
 
-	if err = m.storage.InsertMigration(ctx, migration); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *migrator) Redo(ctx context.Context) error {
-	m.logger.Info("Redo migration start")
+// execStatements выполняет запросы SQL-миграции по одному, чтобы ошибка указывала на конкретный запрос.
+func (m *migrator) execStatements(statements []statement, source Source) MigrateFunc {
+	return func(ctx context.Context, tx entity.Executor) error {
+		for i, stmt := range statements {
+			line := stmt.line
+			location := fmt.Sprintf("line %d", line)
 
-	err := m.Down(ctx)
-	if err != nil {
-		m.logError(ErrMigrationRedo, err)
-		return err
+			if source.File != "" {
+				line += source.Line - 1
+				location = fmt.Sprintf("%s:%d", source.File, line)
+			}
+
+			m.logger.Info(fmt.Sprintf("Statement %d/%d at %s", i+1, len(statements), location))
+
+			if err := tx.Exec(ctx, stmt.sql); err != nil {
+				return &StatementError{Index: i + 1, File: source.File, Line: line, Err: err}
+			}
+		}
+
+		return nil
+	}
+}
+
+// waitLockRetry ждёт перед повтором миграции, не дождавшейся блокировки, или отмены контекста.
+func (m *migrator) waitLockRetry(ctx context.Context, migration entity.Migration, attempt int) error {
+	backoff := m.lockRetryBackoff << attempt
+
+	m.logger.Info(fmt.Sprintf("Migration %d %s: lock timeout, retry %d/%d in %s",
+		migration.GetVersion(), migration.GetName(), attempt+1, m.lockRetries, backoff))
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
-	lastVersion := 0
+// execMigration выполняет миграцию с записью статусов. session означает, что миграция идёт вне
+// транзакции и её ограничения времени заданы на всю сессию.
+func (m *migrator) execMigration(ctx context.Context, db storage.Storage, migration entity.Migration,
+	fn MigrateFunc, timeouts storage.Timeouts, session bool, startStatus, endStatus string,
+) (err error) {
+	start := time.Now()
 
-	lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, postgres.StatusSuccess)
-	if err == nil {
-		lastVersion = lastMigration.GetVersion()
-	} else if err != postgres.ErrMigrationNotFound {
-		m.logError(ErrMigrationRedo, err)
+	migration.SetStatus(startStatus)
+	migration.SetStatusChangeTime(start)
+	migration.SetFailure(entity.Failure{})
+
+	if err = m.saveStatus(ctx, db, migration, nil); err != nil {
 		return err
 	}
 
-	if lastMigration != nil && lastMigration.GetVersion()-1 > len(m.migrations) {
-		m.logError(ErrMigrationRedo, ErrUnexpectedMigrationVersion)
-		return ErrUnexpectedMigrationVersion
+	if err = db.SetTimeouts(ctx, timeouts); err != nil {
+		return err
+	}
+
+	err = fn(ctx, db)
+
+	// Ограничения сессии сбрасываются до записи статуса и снятия блокировки мигратора.
+	if session {
+		if errReset := db.SetTimeouts(ctx, storage.Timeouts{}); errReset != nil && err == nil {
+			err = errReset
+		}
 	}
 
-	err = m.upMigration(ctx, &m.migrations[lastVersion], m.migrations[lastVersion].up)
 	if err != nil {
-		m.logError(ErrMigrationRedo, err)
 		return err
 	}
 
+	end := time.Now()
+
+	migration.SetStatus(endStatus)
+	migration.SetStatusChangeTime(end)
+	migration.SetDuration(end.Sub(start))
+
+	if endStatus == storage.StatusSuccess {
+		migration.SetAppliedTime(end)
+	} else {
+		migration.SetAppliedTime(time.Time{})
+	}
+
+	return m.saveStatus(ctx, db, migration, nil)
+}
+
+func (m *migrator) Redo(ctx context.Context) ([]StatusRecord, error) {
+	m.logger.Info("Redo migration start")
+
+	if err := m.withLock(ctx, ErrMigrationRedo, func() error { return m.redo(ctx) }); err != nil {
+		return m.processed, err
+	}
+
 	m.logger.Info("Redo migration end")
-	return nil
+	return m.processed, nil
 }
 
-func (m *migrator) Status(ctx context.Context) error {
-	migrations, err := m.storage.SelectMigrations(ctx)
+func (m *migrator) redo(ctx context.Context) error {
+	last, err := m.lastApplied(ctx)
 	if err != nil {
-		m.logError(ErrGetStatus, err)
 		return err
 	}
-	m.logger.Info("._____________________._____________________._____________________.")
-	m.logger.Info(fmt.Sprintf("| %-19s | %-19s | %-19s |", "Название", "Статус", "Время"))
 
-	for _, migr := range migrations {
-		formatMigration := fmt.Sprintf("| %-19s | %-19s | %s |",
-			migr.GetName(), migr.GetStatus(), migr.GetStatusChangeTime().Format("2006-01-02 15:04:05"))
+	if err = m.downMigration(ctx, last[0]); err != nil {
+		return err
+	}
+
+	return m.upMigration(ctx, last[0])
+}
 
-		m.logger.Info(formatMigration)
+// Status возвращает версионные миграции от последней к первой, а за ними повторяемые.
+func (m *migrator) Status(ctx context.Context) ([]StatusRecord, error) {
+	migrations, err := m.storage.SelectMigrations(ctx)
+	if err != nil && err != storage.ErrMigrationNotFound {
+		return nil, m.logError(ErrGetStatus, err)
 	}
 
-	m.logger.Info("|_____________________|_____________________|_____________________|")
-	return nil
+	records := make([]StatusRecord, 0, len(migrations))
+	for _, migration := range migrations {
+		record := newStatusRecord(migration)
+		record.ChecksumMismatch = m.checksumMismatch(migration)
+
+		records = append(records, record)
+	}
+
+	repeatable, err := m.repeatableStatus(ctx)
+	if err != nil {
+		return nil, m.logError(ErrGetStatus, err)
+	}
+
+	return append(records, repeatable...), nil
+}
+
+func (m *migrator) DbVersion(ctx context.Context) (int64, error) {
+	lastVersion, err := m.lastVersion(ctx)
+	if err != nil {
+		return 0, m.logError(ErrGetVersion, err)
+	}
+
+	return lastVersion, nil
 }
 
-func (m *migrator) DbVersion(ctx context.Context) error {
-	lastVersion := 0
+func (m *migrator) Validate(ctx context.Context) ([]StatusRecord, error) {
+	m.logger.Info("Validate migrations start")
 
-	lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, postgres.StatusSuccess)
-	if err == nil {
-		lastVersion = lastMigration.GetVersion()
-	} else if err != postgres.ErrMigrationNotFound {
-		m.logError(ErrGetVersion, err)
+	mismatches, err := m.selectApplied(ctx, m.checksumMismatch)
+	if err != nil {
+		return nil, m.logError(ErrValidate, err)
+	}
+
+	if len(mismatches) > 0 {
+		err = m.mismatchError(mismatches)
+		return mismatches, m.logError(ErrValidate, err)
+	}
+
+	m.logger.Info("Validate migrations end")
+	return mismatches, nil
+}
+
+func (m *migrator) Repair(ctx context.Context) ([]StatusRecord, error) {
+	m.logger.Info("Repair checksums start")
+
+	err := m.withLock(ctx, ErrRepair, func() error {
+		mismatches, err := m.selectApplied(ctx, m.checksumOutdated)
+		if err != nil {
+			return err
+		}
+
+		for _, mismatch := range mismatches {
+			local := m.find(mismatch.Version)
+
+			m.logger.Info(fmt.Sprintf("Repair checksum of migration %d %s", mismatch.Version, mismatch.Name))
+			if err = m.storage.UpdateChecksum(ctx, mismatch.Version, local.checksum); err != nil {
+				return err
+			}
+
+			mismatch.Checksum = local.checksum
+			mismatch.ChecksumMismatch = false
+			m.processed = append(m.processed, mismatch)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info("Repair checksums end")
+	return m.processed, nil
+}
+
+func (m *migrator) verifyChecksums(ctx context.Context) error {
+	mismatches, err := m.selectApplied(ctx, m.checksumMismatch)
+	if err != nil {
 		return err
 	}
 
-	m.logger.Info(fmt.Sprintf("Version: %d", lastVersion))
+	if len(mismatches) > 0 {
+		return m.mismatchError(mismatches)
+	}
+
 	return nil
 }
+
+// selectApplied возвращает применённые миграции, удовлетворяющие условию, в порядке возрастания версий.
+func (m *migrator) selectApplied(ctx context.Context, filter func(entity.Migration) bool) ([]StatusRecord, error) {
+	migrations, err := m.storage.SelectMigrations(ctx)
+	if err == storage.ErrMigrationNotFound {
+		return []StatusRecord{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	records := make([]StatusRecord, 0)
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		status := migrations[i].GetStatus()
+		if (status == storage.StatusSuccess || status == storage.StatusBaseline) && filter(migrations[i]) {
+			record := newStatusRecord(migrations[i])
+			record.ChecksumMismatch = m.checksumMismatch(migrations[i])
+
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// checksumMismatch сообщает, что файл применённой миграции изменился. Миграции без сохранённой
+// контрольной суммы (применённые до её появления и Go-миграции) не проверяются.
+func (m *migrator) checksumMismatch(applied entity.Migration) bool {
+	local := m.find(applied.GetVersion())
+	if local == nil || local.checksum == "" || applied.GetChecksum() == "" {
+		return false
+	}
+
+	return local.checksum != applied.GetChecksum()
+}
+
+func (m *migrator) checksumOutdated(applied entity.Migration) bool {
+	local := m.find(applied.GetVersion())
+
+	return local != nil && local.checksum != "" && local.checksum != applied.GetChecksum()
+}
+
+func (m *migrator) mismatchError(mismatches []StatusRecord) error {
+	versions := make([]int64, 0, len(mismatches))
+
+	for _, mismatch := range mismatches {
+		m.logger.Error(fmt.Sprintf("Checksum mismatch for migration %d %s", mismatch.Version, mismatch.Name))
+		versions = append(versions, mismatch.Version)
+	}
+
+	return fmt.Errorf("%w: %s", ErrChecksumMismatch, joinVersions(versions))
+}
+
+func joinVersions(versions []int64) string {
+	strs := make([]string, 0, len(versions))
+	for _, version := range versions {
+		strs = append(strs, strconv.FormatInt(version, 10))
+	}
+
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,902 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	_ "github.com/MyLi2tlePony/sql-migrator/pkg/storage/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+var errFakeExec = errors.New("fake exec error")
+
+type logg struct{}
+
+func (l *logg) Error(msg string) {}
+
+func (l *logg) Info(msg string) {}
+
+type fakeStorage struct {
+	migrations map[int64]entity.Migration
+	repeatable map[string]entity.Migration
+	history    []entity.History
+	executed   []string
+	locked     bool
+
+	timeouts []storage.Timeouts
+	// lockFailures — сколько раз запрос с LOCK завершится ошибкой lock_timeout.
+	lockFailures int
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		migrations: make(map[int64]entity.Migration),
+		repeatable: make(map[string]entity.Migration),
+	}
+}
+
+func onlyErr(_ []StatusRecord, err error) error {
+	return err
+}
+
+func newTestMigrator(store storage.Storage) *migrator {
+	return &migrator{
+		logger:           &logg{},
+		storage:          store,
+		lockTimeout:      DefaultLockTimeout,
+		lockPollInterval: defaultLockPollInterval,
+		lockRetryBackoff: time.Millisecond,
+	}
+}
+
+func (s *fakeStorage) Exec(_ context.Context, sql string, _ ...interface{}) error {
+	if i := strings.Index(sql, "FAIL"); i >= 0 {
+		return &storage.DBError{Code: "42601", Message: "syntax error", Position: i + 1, Err: errFakeExec}
+	}
+
+	if strings.Contains(sql, "LOCK") && s.lockFailures > 0 {
+		s.lockFailures--
+		return &storage.DBError{Code: storage.CodeLockNotAvailable, Message: "lock timeout", Err: errFakeExec}
+	}
+
+	s.executed = append(s.executed, sql)
+	return nil
+}
+
+func (s *fakeStorage) Query(context.Context, string, ...interface{}) (entity.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeStorage) QueryRow(context.Context, string, ...interface{}) entity.Row {
+	return nil
+}
+
+func (s *fakeStorage) SelectMigrations(context.Context) ([]entity.Migration, error) {
+	versions := make([]int64, 0, len(s.migrations))
+	for version := range s.migrations {
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		return nil, storage.ErrMigrationNotFound
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	migrations := make([]entity.Migration, 0, len(versions))
+	for _, version := range versions {
+		migrations = append(migrations, s.migrations[version])
+	}
+
+	return migrations, nil
+}
+
+func (s *fakeStorage) SelectRepeatableMigrations(context.Context) ([]entity.Migration, error) {
+	names := make([]string, 0, len(s.repeatable))
+	for name := range s.repeatable {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	migrations := make([]entity.Migration, 0, len(names))
+	for _, name := range names {
+		migrations = append(migrations, s.repeatable[name])
+	}
+
+	return migrations, nil
+}
+
+func (s *fakeStorage) SelectLastMigrationByStatus(ctx context.Context, status string) (entity.Migration, error) {
+	migrations, err := s.SelectMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if m.GetStatus() == status {
+			return m, nil
+		}
+	}
+
+	return nil, storage.ErrMigrationNotFound
+}
+
+func (s *fakeStorage) Connect(context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) Close(context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) InsertMigration(_ context.Context, m entity.Migration) error {
+	stored := entity.NewMigration(m.GetName(), m.GetStatus(), m.GetVersion(), m.GetStatusChangeTime())
+	stored.SetAppliedTime(m.GetAppliedTime())
+	stored.SetDuration(m.GetDuration())
+	stored.SetChecksum(m.GetChecksum())
+	stored.SetFailure(m.GetFailure())
+
+	if m.GetVersion() == RepeatableVersion {
+		s.repeatable[m.GetName()] = stored
+		return nil
+	}
+
+	s.migrations[m.GetVersion()] = stored
+	return nil
+}
+
+func (s *fakeStorage) UpdateChecksum(_ context.Context, version int64, checksum string) error {
+	if m, ok := s.migrations[version]; ok {
+		m.SetChecksum(checksum)
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) UpdateVersion(_ context.Context, from, to int64) error {
+	if m, ok := s.migrations[from]; ok {
+		delete(s.migrations, from)
+		m.SetVersion(to)
+		s.migrations[to] = m
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) InsertHistory(_ context.Context, history entity.History) error {
+	s.history = append(s.history, history)
+	return nil
+}
+
+func (s *fakeStorage) SelectHistory(_ context.Context, version int64) ([]entity.History, error) {
+	history := make([]entity.History, 0)
+
+	for _, entry := range s.history {
+		if version == 0 || entry.Version == version {
+			history = append(history, entry)
+		}
+	}
+
+	return history, nil
+}
+
+func (s *fakeStorage) Migrate(ctx context.Context, sql string) error {
+	return s.Exec(ctx, sql)
+}
+
+func (s *fakeStorage) Init(context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) SetTimeouts(_ context.Context, timeouts storage.Timeouts) error {
+	s.timeouts = append(s.timeouts, timeouts)
+	return nil
+}
+
+func (s *fakeStorage) DeleteMigrations(context.Context) error {
+	s.migrations = make(map[int64]entity.Migration)
+	return nil
+}
+
+func (s *fakeStorage) TryLock(context.Context) (bool, error) {
+	if s.locked {
+		return false, nil
+	}
+
+	s.locked = true
+	return true, nil
+}
+
+func (s *fakeStorage) Unlock(context.Context) error {
+	if !s.locked {
+		return storage.ErrLockNotHeld
+	}
+
+	s.locked = false
+	return nil
+}
+
+func (s *fakeStorage) LockHolder(context.Context) (int, error) {
+	return 1, nil
+}
+
+func (s *fakeStorage) Transaction(ctx context.Context, fn func(storage.Storage) error) error {
+	migrations := make(map[int64]entity.Migration, len(s.migrations))
+	for version, m := range s.migrations {
+		migrations[version] = m
+	}
+
+	repeatable := make(map[string]entity.Migration, len(s.repeatable))
+	for name, m := range s.repeatable {
+		repeatable[name] = m
+	}
+
+	executed := len(s.executed)
+	history := len(s.history)
+
+	if err := fn(s); err != nil {
+		s.migrations = migrations
+		s.repeatable = repeatable
+		s.executed = s.executed[:executed]
+		s.history = s.history[:history]
+		return err
+	}
+
+	return nil
+}
+
+func (s *fakeStorage) status(version int64) string {
+	if m, ok := s.migrations[version]; ok {
+		return m.GetStatus()
+	}
+
+	return ""
+}
+
+func TestMigratorUp(t *testing.T) {
+	t.Run("sql and go migrations in version order", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		m := newTestMigrator(store)
+
+		m.Create(3, "third", "SELECT 3;", "")
+		m.Create(1, "first", "SELECT 1;", "")
+		m.migrations = append(m.migrations, migration{
+			version: 2,
+			name:    "second",
+			upFunc: func(ctx context.Context, tx entity.Executor) error {
+				return tx.Exec(ctx, "SELECT 2;")
+			},
+			downFunc: noopMigrateFunc,
+		})
+		m.Create(4, "fourth", "SELECT 4;", "")
+
+		records, err := m.Up(ctx)
+		require.Nil(t, err)
+		require.Len(t, records, 4)
+		require.Equal(t, "second", records[1].Name)
+		require.Equal(t, storage.StatusSuccess, records[1].Status)
+		require.Equal(t, []string{"SELECT 1;", "SELECT 2;", "SELECT 3;", "SELECT 4;"}, store.executed)
+		require.False(t, store.locked)
+
+		for version := int64(1); version <= 4; version++ {
+			require.Equal(t, storage.StatusSuccess, store.status(version))
+		}
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		m := newTestMigrator(store)
+
+		m.Create(1, "first", "SELECT 1;", "")
+		m.Create(2, "second", "FAIL;", "")
+
+		records, err := m.Up(ctx)
+		require.ErrorIs(t, err, errFakeExec)
+		require.ErrorIs(t, err, ErrMigrationUp)
+		require.Len(t, records, 2)
+		require.Equal(t, storage.StatusError, records[1].Status)
+		require.Equal(t, []string{"SELECT 1;"}, store.executed)
+		require.Equal(t, storage.StatusSuccess, store.status(1))
+		require.Equal(t, storage.StatusError, store.status(2))
+		require.Equal(t, entity.Failure{Code: "42601", Message: "syntax error", Position: 1, Line: 1},
+			store.migrations[2].GetFailure())
+		require.False(t, store.locked)
+	})
+
+	t.Run("failure is mapped to the file line", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		m := newTestMigrator(store)
+
+		m.Add(SQLMigration{
+			Version:  1,
+			Name:     "first",
+			Up:       "CREATE TABLE t (id INTEGER);\n\n-- broken\nINSERT INTO t\nVALUES (FAIL);\n",
+			UpSource: Source{File: "db/00001_first.sql", Line: 3},
+		})
+
+		records, err := m.Up(ctx)
+		require.ErrorIs(t, err, errFakeExec)
+		require.Equal(t, "db/00001_first.sql", records[0].Failure.File)
+		require.Equal(t, 6, records[0].Failure.Line)
+
+		require.Nil(t, store.InsertMigration(ctx, entity.NewMigration("first", storage.StatusCancel, 1, time.Now())))
+		m.migrations[0].up = "SELECT 1;"
+
+		records, err = m.Up(ctx)
+		require.Nil(t, err)
+		require.Empty(t, records[0].Failure.Message)
+	})
+
+	t.Run("lock timeout", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		store.locked = true
+
+		m := newTestMigrator(store)
+		m.lockTimeout = 0
+
+		err := onlyErr(m.Up(ctx))
+		require.ErrorIs(t, err, ErrLockTimeout)
+		require.ErrorIs(t, err, ErrMigrationUp)
+	})
+}
+
+func TestMigratorDownRedo(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "SELECT 2;", "SELECT -2;")
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Nil(t, onlyErr(m.Redo(ctx)))
+	require.Nil(t, onlyErr(m.Down(ctx)))
+
+	require.Equal(t, []string{"SELECT 1;", "SELECT 2;", "SELECT -2;", "SELECT 2;", "SELECT -2;"}, store.executed)
+	require.Equal(t, storage.StatusSuccess, store.status(1))
+	require.Equal(t, storage.StatusCancel, store.status(2))
+}
+
+func TestMigratorDownFailed(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "users", "CREATE TABLE users (id INT);", "DROP TABLE users;\nFAIL;")
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	applied := store.migrations[1].GetStatusChangeTime()
+
+	records, err := m.Down(ctx)
+	require.ErrorIs(t, err, errFakeExec)
+	require.Len(t, records, 1)
+	require.Equal(t, storage.StatusSuccess, records[0].Status)
+	require.Equal(t, "42601", records[0].Failure.Code)
+
+	require.Equal(t, storage.StatusSuccess, store.status(1))
+	require.True(t, applied.Equal(store.migrations[1].GetStatusChangeTime()))
+	require.Equal(t, []string{"CREATE TABLE users (id INT);"}, store.executed)
+
+	last := store.history[len(store.history)-1]
+	require.Equal(t, storage.StatusError, last.Status)
+	require.NotEmpty(t, last.Error)
+
+	version, err := m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), version)
+
+	records, err = m.Up(ctx)
+	require.Nil(t, err)
+	require.Empty(t, records)
+}
+
+func TestMigratorUpToDownTo(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	for version := int64(1); version <= 4; version++ {
+		m.Create(version, "m", fmt.Sprintf("SELECT %d;", version), fmt.Sprintf("SELECT -%d;", version))
+	}
+
+	require.ErrorIs(t, onlyErr(m.UpTo(ctx, 5)), ErrUnknownTargetVersion)
+
+	require.Nil(t, onlyErr(m.UpTo(ctx, 2)))
+	require.Equal(t, []string{"SELECT 1;", "SELECT 2;"}, store.executed)
+
+	records, err := m.UpTo(ctx, 2)
+	require.Nil(t, err)
+	require.Empty(t, records)
+	require.Equal(t, []string{"SELECT 1;", "SELECT 2;"}, store.executed)
+
+	require.ErrorIs(t, onlyErr(m.UpTo(ctx, 1)), ErrTargetVersionApplied)
+	require.ErrorIs(t, onlyErr(m.DownTo(ctx, 3)), ErrTargetVersionAhead)
+
+	require.Nil(t, onlyErr(m.UpTo(ctx, 4)))
+	require.Nil(t, onlyErr(m.DownTo(ctx, 1)))
+	require.Equal(t, []string{
+		"SELECT 1;", "SELECT 2;", "SELECT 3;", "SELECT 4;", "SELECT -4;", "SELECT -3;", "SELECT -2;",
+	}, store.executed)
+
+	require.Nil(t, onlyErr(m.DownTo(ctx, 0)))
+	require.Equal(t, storage.StatusCancel, store.status(1))
+}
+
+func TestMigratorOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "")
+	m.Create(3, "third", "SELECT 3;", "")
+	require.Nil(t, onlyErr(m.Up(ctx)))
+
+	m.Create(2, "merged", "SELECT 2;", "")
+	m.Create(4, "fourth", "SELECT 4;", "")
+
+	err := onlyErr(m.Up(ctx))
+	require.ErrorIs(t, err, ErrOutOfOrder)
+	require.ErrorIs(t, err, ErrMigrationUp)
+	require.Equal(t, "", store.status(4))
+
+	m.allowOutOfOrder = true
+	records, err := m.Up(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, []string{"SELECT 1;", "SELECT 3;", "SELECT 2;", "SELECT 4;"}, store.executed)
+
+	m.Create(4, "fourth_again", "SELECT 4;", "")
+	require.ErrorIs(t, onlyErr(m.Up(ctx)), ErrDuplicateVersion)
+
+	unknown := newTestMigrator(store)
+	unknown.Create(1, "first", "SELECT 1;", "")
+	require.ErrorIs(t, onlyErr(unknown.Up(ctx)), ErrUnexpectedMigrationVersion)
+}
+
+func TestMigratorForce(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "")
+	m.Create(2, "second", "SELECT 2;", "")
+	m.Create(3, "third", "SELECT 3;", "")
+
+	stuck := entity.NewMigration("first", storage.StatusProcess, 1, time.Now())
+	require.Nil(t, store.InsertMigration(ctx, stuck))
+
+	err := onlyErr(m.Up(ctx))
+	require.ErrorIs(t, err, ErrDirty)
+	require.ErrorIs(t, err, ErrMigrationUp)
+	require.Empty(t, store.executed)
+
+	_, err = m.Plan(ctx, ActionUp, 0)
+	require.ErrorIs(t, err, ErrDirty)
+
+	require.ErrorIs(t, onlyErr(m.Force(ctx, 1, "done")), ErrUnknownForceStatus)
+	require.ErrorIs(t, onlyErr(m.Force(ctx, 7, ForceApplied)), ErrUnknownTargetVersion)
+
+	records, err := m.Force(ctx, 1, ForceApplied)
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, storage.StatusSuccess, store.status(1))
+	require.False(t, store.migrations[1].GetAppliedTime().IsZero())
+	require.NotEmpty(t, store.migrations[1].GetChecksum())
+	require.False(t, store.locked)
+
+	require.Nil(t, store.InsertMigration(ctx, entity.NewMigration("second", storage.StatusCancellation, 2, time.Now())))
+	require.ErrorIs(t, onlyErr(m.Up(ctx)), ErrDirty)
+
+	require.Nil(t, onlyErr(m.Force(ctx, 2, ForceRolledBack)))
+	require.Equal(t, storage.StatusCancel, store.status(2))
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Equal(t, []string{"SELECT 2;", "SELECT 3;"}, store.executed)
+	require.Equal(t, storage.StatusSuccess, store.history[len(store.history)-1].Status)
+}
+
+func TestMigratorTimeouts(t *testing.T) {
+	t.Run("header overrides config", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		m := newTestMigrator(store)
+		WithTimeouts(time.Minute, 5*time.Second)(m)
+
+		m.Create(1, "first", "SELECT 1;", "")
+		m.Create(2, "second", "-- +migrate LockTimeout 1s\n-- +migrate StatementTimeout 0\nSELECT 2;", "")
+
+		require.Nil(t, onlyErr(m.Up(ctx)))
+		require.Equal(t, []storage.Timeouts{
+			{Statement: time.Minute, Lock: 5 * time.Second},
+			{Statement: 0, Lock: time.Second},
+		}, store.timeouts)
+	})
+
+	t.Run("lock timeout is retried", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		store.lockFailures = 2
+		m := newTestMigrator(store)
+		WithLockRetries(2, time.Millisecond)(m)
+
+		m.Create(1, "lock", "SELECT 1;\nLOCK TABLE t;", "")
+
+		require.Nil(t, onlyErr(m.Up(ctx)))
+		require.Equal(t, storage.StatusSuccess, store.status(1))
+		require.Equal(t, []string{"SELECT 1;", "LOCK TABLE t;"}, store.executed)
+		require.Len(t, store.timeouts, 3)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		store.lockFailures = 2
+		m := newTestMigrator(store)
+		WithLockRetries(1, time.Millisecond)(m)
+
+		m.Create(1, "lock", "LOCK TABLE t;", "")
+
+		err := onlyErr(m.Up(ctx))
+		require.True(t, storage.IsLockTimeout(err))
+		require.Equal(t, storage.StatusError, store.status(1))
+		require.Equal(t, storage.CodeLockNotAvailable, store.migrations[1].GetFailure().Code)
+	})
+
+	t.Run("no transaction is not retried", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		store.lockFailures = 1
+		m := newTestMigrator(store)
+		WithLockRetries(3, time.Millisecond)(m)
+
+		m.Create(1, "lock", "-- +migrate NoTransaction\nSELECT 1;\nLOCK TABLE t;", "")
+
+		require.True(t, storage.IsLockTimeout(onlyErr(m.Up(ctx))))
+		require.Equal(t, []string{"SELECT 1;"}, store.executed)
+		require.Equal(t, []storage.Timeouts{{}, {}}, store.timeouts)
+	})
+
+	t.Run("no transaction resets session timeouts", func(t *testing.T) {
+		ctx := context.Background()
+		store := newFakeStorage()
+		m := newTestMigrator(store)
+		WithTimeouts(time.Minute, 5*time.Second)(m)
+
+		m.Create(1, "index", "-- +migrate NoTransaction\nCREATE INDEX CONCURRENTLY idx ON t (id);", "")
+
+		require.Nil(t, onlyErr(m.Up(ctx)))
+		require.Equal(t, []storage.Timeouts{{Statement: time.Minute, Lock: 5 * time.Second}, {}}, store.timeouts)
+	})
+}
+
+func TestMigratorRepeatable(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "init", "CREATE TABLE t (id INT);", "DROP TABLE t;")
+	m.Create(RepeatableVersion, "views", "CREATE OR REPLACE VIEW v AS SELECT id FROM t;", "")
+	m.Create(RepeatableVersion, "grants", "GRANT SELECT ON v TO app;", "")
+
+	require.Nil(t, onlyErr(m.UpTo(ctx, 1)))
+	require.Equal(t, []string{"CREATE TABLE t (id INT);"}, store.executed)
+
+	records, err := m.Up(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, []string{
+		"CREATE TABLE t (id INT);", "GRANT SELECT ON v TO app;", "CREATE OR REPLACE VIEW v AS SELECT id FROM t;",
+	}, store.executed)
+	require.Equal(t, storage.StatusSuccess, store.repeatable["views"].GetStatus())
+
+	records, err = m.Up(ctx)
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	dbVersion, err := m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), dbVersion)
+
+	m.repeatable[1] = migration{
+		version: RepeatableVersion, name: "views", up: "CREATE OR REPLACE VIEW v AS SELECT id, FAIL FROM t;",
+	}
+	m.repeatable[1].checksum = checksum(m.repeatable[1].up, "")
+
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, status, 3)
+	require.Equal(t, RepeatableVersion, status[2].Version)
+	require.Equal(t, "views", status[2].Name)
+	require.True(t, status[2].ChecksumMismatch)
+	require.False(t, status[1].ChecksumMismatch)
+
+	steps, err := m.Plan(ctx, ActionUp, 0)
+	require.Nil(t, err)
+	require.Len(t, steps, 1)
+	require.Equal(t, "views", steps[0].Name)
+
+	require.ErrorIs(t, onlyErr(m.Up(ctx)), errFakeExec)
+	require.Equal(t, storage.StatusError, store.repeatable["views"].GetStatus())
+
+	m.repeatable[1].up = "CREATE OR REPLACE VIEW v AS SELECT id, 1 FROM t;"
+	m.repeatable[1].checksum = checksum(m.repeatable[1].up, "")
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Equal(t, storage.StatusSuccess, store.repeatable["views"].GetStatus())
+	require.Equal(t, "CREATE OR REPLACE VIEW v AS SELECT id, 1 FROM t;", store.executed[len(store.executed)-1])
+	require.Equal(t, storage.StatusSuccess, store.status(1))
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "DROP 1;")
+	m.Create(2, "second", "SELECT 2;", "DROP 2;")
+	m.Create(3, "third", "SELECT 3;", "DROP 3;")
+
+	require.ErrorIs(t, onlyErr(m.Baseline(ctx, 5)), ErrUnknownTargetVersion)
+
+	records, err := m.Baseline(ctx, 2)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Empty(t, store.executed)
+	require.Equal(t, storage.StatusBaseline, store.status(1))
+	require.Equal(t, storage.StatusBaseline, store.status(2))
+	require.Equal(t, "", store.status(3))
+	require.Len(t, store.history, 2)
+	require.False(t, store.locked)
+
+	dbVersion, err := m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), dbVersion)
+
+	err = onlyErr(m.Baseline(ctx, 3))
+	require.ErrorIs(t, err, ErrBaselineNotEmpty)
+	require.ErrorIs(t, err, ErrBaseline)
+
+	require.ErrorIs(t, onlyErr(m.Down(ctx)), ErrBaselineRollback)
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Equal(t, []string{"SELECT 3;"}, store.executed)
+
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.Equal(t, storage.StatusSuccess, status[0].Status)
+	require.Equal(t, storage.StatusBaseline, status[1].Status)
+
+	require.Nil(t, onlyErr(m.Down(ctx)))
+	require.Equal(t, "DROP 3;", store.executed[len(store.executed)-1])
+	require.ErrorIs(t, onlyErr(m.DownTo(ctx, 1)), ErrBaselineRollback)
+	require.ErrorIs(t, onlyErr(m.Redo(ctx)), ErrBaselineRollback)
+}
+
+func TestMigratorHistory(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+	m.origin = origin{hostname: "host", user: "deploy", toolVersion: "v1.0.0"}
+
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "FAIL;", "")
+
+	require.ErrorIs(t, onlyErr(m.Up(ctx)), ErrMigrationUp)
+	require.Nil(t, onlyErr(m.Down(ctx)))
+
+	history, err := m.History(ctx, 0)
+	require.Nil(t, err)
+
+	statuses := make([]string, 0, len(history))
+	for _, entry := range history {
+		statuses = append(statuses, fmt.Sprintf("%d %s", entry.Version, entry.Status))
+	}
+
+	require.Equal(t, []string{
+		"1 " + storage.StatusProcess,
+		"1 " + storage.StatusSuccess,
+		"2 " + storage.StatusError,
+		"1 " + storage.StatusCancellation,
+		"1 " + storage.StatusCancel,
+	}, statuses)
+
+	require.Equal(t, "statement 1 (line 1): "+errFakeExec.Error(), history[2].Error)
+	require.Equal(t, "host", history[0].Hostname)
+	require.Equal(t, "deploy", history[0].User)
+	require.Equal(t, "v1.0.0", history[0].ToolVersion)
+
+	history, err = m.History(ctx, 2)
+	require.Nil(t, err)
+	require.Len(t, history, 1)
+}
+
+func TestMigratorPlan(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "-- +migrate NoTransaction\nSELECT 2;", "SELECT -2;")
+	m.Create(3, "third", "SELECT 3;", "SELECT -3;")
+
+	steps, err := m.Plan(ctx, ActionUpTo, 2)
+	require.Nil(t, err)
+	require.Equal(t, []PlanStep{
+		{Version: 1, Name: "first", Direction: DirectionUp, SQL: "SELECT 1;"},
+		{Version: 2, Name: "second", Direction: DirectionUp, SQL: "-- +migrate NoTransaction\nSELECT 2;", NoTransaction: true},
+	}, steps)
+
+	_, err = m.Plan(ctx, ActionDown, 0)
+	require.ErrorIs(t, err, ErrPlan)
+	require.Empty(t, store.executed)
+	require.Empty(t, store.migrations)
+
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	executed := len(store.executed)
+
+	steps, err = m.Plan(ctx, ActionUp, 0)
+	require.Nil(t, err)
+	require.Empty(t, steps)
+
+	steps, err = m.Plan(ctx, ActionDownTo, 1)
+	require.Nil(t, err)
+	require.Len(t, steps, 2)
+	require.Equal(t, int64(3), steps[0].Version)
+	require.Equal(t, "SELECT -2;", steps[1].SQL)
+
+	steps, err = m.Plan(ctx, ActionRedo, 0)
+	require.Nil(t, err)
+	require.Len(t, steps, 2)
+	require.Equal(t, DirectionDown, steps[0].Direction)
+	require.Equal(t, DirectionUp, steps[1].Direction)
+
+	require.Len(t, store.executed, executed)
+	require.Equal(t, storage.StatusSuccess, store.status(3))
+}
+
+func TestMigratorStatusDbVersion(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	records, err := m.Status(ctx)
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	version, err := m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), version)
+
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "SELECT 2;", "SELECT -2;")
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Nil(t, onlyErr(m.Down(ctx)))
+
+	version, err = m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), version)
+
+	records, err = m.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+
+	require.Equal(t, int64(2), records[0].Version)
+	require.Equal(t, "second", records[0].Name)
+	require.Equal(t, storage.StatusCancel, records[0].Status)
+	require.True(t, records[0].AppliedTime.IsZero())
+
+	require.Equal(t, int64(1), records[1].Version)
+	require.Equal(t, storage.StatusSuccess, records[1].Status)
+	require.False(t, records[1].AppliedTime.IsZero())
+	require.Equal(t, records[1].StatusChangeTime, records[1].AppliedTime)
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+
+	m := newTestMigrator(store)
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "SELECT 2;", "SELECT -2;")
+	require.Nil(t, onlyErr(m.Up(ctx)))
+	require.Equal(t, checksum("SELECT 1;", "SELECT -1;"), store.migrations[1].GetChecksum())
+
+	mismatches, err := m.Validate(ctx)
+	require.Nil(t, err)
+	require.Empty(t, mismatches)
+
+	edited := newTestMigrator(store)
+	edited.Create(1, "first", "SELECT 1; -- edited", "SELECT -1;")
+	edited.Create(2, "second", "SELECT 2;", "SELECT -2;")
+	edited.Create(3, "third", "SELECT 3;", "SELECT -3;")
+
+	mismatches, err = edited.Validate(ctx)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	require.Len(t, mismatches, 1)
+	require.Equal(t, int64(1), mismatches[0].Version)
+
+	records, err := edited.Status(ctx)
+	require.Nil(t, err)
+	require.False(t, records[0].ChecksumMismatch)
+	require.True(t, records[1].ChecksumMismatch)
+
+	require.ErrorIs(t, onlyErr(edited.Up(ctx)), ErrChecksumMismatch)
+	require.Equal(t, "", store.status(3))
+
+	repaired, err := edited.Repair(ctx)
+	require.Nil(t, err)
+	require.Len(t, repaired, 1)
+	require.Equal(t, checksum("SELECT 1; -- edited", "SELECT -1;"), store.migrations[1].GetChecksum())
+
+	require.Nil(t, onlyErr(edited.Up(ctx)))
+	require.Equal(t, storage.StatusSuccess, store.status(3))
+
+	baselined := newFakeStorage()
+	m = newTestMigrator(baselined)
+	m.Create(1, "first", "SELECT 1;", "SELECT -1;")
+	m.Create(2, "second", "SELECT 2;", "SELECT -2;")
+	require.Nil(t, onlyErr(m.Baseline(ctx, 1)))
+
+	edited = newTestMigrator(baselined)
+	edited.Create(1, "first", "SELECT 1; -- edited", "SELECT -1;")
+	edited.Create(2, "second", "SELECT 2;", "SELECT -2;")
+
+	mismatches, err = edited.Validate(ctx)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	require.Len(t, mismatches, 1)
+	require.Equal(t, storage.StatusBaseline, mismatches[0].Status)
+
+	require.ErrorIs(t, onlyErr(edited.Up(ctx)), ErrChecksumMismatch)
+	require.Equal(t, "", baselined.status(2))
+
+	repaired, err = edited.Repair(ctx)
+	require.Nil(t, err)
+	require.Len(t, repaired, 1)
+	require.Equal(t, checksum("SELECT 1; -- edited", "SELECT -1;"), baselined.migrations[1].GetChecksum())
+	require.Equal(t, storage.StatusBaseline, baselined.status(1))
+}
+
+func TestMigratorSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := New("unknown://db", &logg{})
+	require.ErrorIs(t, err, storage.ErrUnknownDriver)
+
+	m, err := New("sqlite://:memory:", &logg{}, WithTable("migrations"))
+	require.Nil(t, err)
+	require.Nil(t, m.Connect(ctx))
+	defer m.Close(ctx)
+
+	m.Create(1, "users", "CREATE TABLE users (id INTEGER);", "DROP TABLE users;")
+	m.Create(2, "seed", "INSERT INTO users VALUES (1); INSERT INTO users VALUES (2);", "DELETE FROM users;")
+	m.Create(3, "broken", "INSERT INTO missing VALUES (1);", "")
+
+	records, err := m.Up(ctx)
+	require.ErrorIs(t, err, ErrMigrationUp)
+	require.Len(t, records, 3)
+	require.Equal(t, storage.StatusError, records[2].Status)
+
+	version, err := m.DbVersion(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), version)
+
+	require.Nil(t, onlyErr(m.DownTo(ctx, 1)))
+
+	records, err = m.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, storage.StatusCancel, records[1].Status)
+	require.Equal(t, storage.StatusSuccess, records[2].Status)
+}
@@ -0,0 +1,117 @@
+package migration
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+const (
+	sectionUp   = "up"
+	sectionDown = "down"
+)
+
+var (
+	ErrMissingMarker      = errors.New("missing marker")
+	ErrDuplicateMarker    = errors.New("duplicate marker")
+	ErrSQLOutsideSections = errors.New("sql before the first section marker")
+	ErrDirectiveOutside   = errors.New("directive before the first section marker, move it under -- +up or -- +down")
+
+	regMarker = regexp.MustCompile(`^--\s*\+(up|down)\s*$`)
+)
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseSQLMigration делит однофайловую миграцию на up и down части по маркерам "-- +up" и "-- +down".
+// До первого маркера допускаются только комментарии и пустые строки, директивы "-- +migrate"
+// пишутся внутри секции, к которой относятся.
+func parseSQLMigration(fileName, content string) (up, down string, err error) {
+	var (
+		sections = map[string]*strings.Builder{}
+		current  *strings.Builder
+		line     int
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		if match := regMarker.FindStringSubmatch(trimmed); match != nil {
+			if _, ok := sections[match[1]]; ok {
+				return "", "", &ParseError{
+					File: fileName,
+					Line: line,
+					Err:  fmt.Errorf("%w %s", ErrDuplicateMarker, marker(match[1])),
+				}
+			}
+
+			current = &strings.Builder{}
+			sections[match[1]] = current
+			continue
+		}
+
+		if current == nil {
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return "", "", &ParseError{File: fileName, Line: line, Err: ErrSQLOutsideSections}
+			}
+
+			if regDirective.MatchString(trimmed) {
+				return "", "", &ParseError{File: fileName, Line: line, Err: ErrDirectiveOutside}
+			}
+
+			continue
+		}
+
+		current.WriteString(text)
+		current.WriteString("\n")
+	}
+
+	if err = scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	for _, section := range []string{sectionUp, sectionDown} {
+		if _, ok := sections[section]; !ok {
+			return "", "", &ParseError{
+				File: fileName,
+				Line: line,
+				Err:  fmt.Errorf("%w %s", ErrMissingMarker, marker(section)),
+			}
+		}
+	}
+
+	return sections[sectionUp].String(), sections[sectionDown].String(), nil
+}
+
+func marker(section string) string {
+	return "-- +" + section
+}
+
+// sectionLine возвращает номер строки, с которой начинается секция однофайловой миграции.
+func sectionLine(content, section string) int {
+	for i, text := range strings.Split(content, "\n") {
+		if match := regMarker.FindStringSubmatch(strings.TrimSpace(text)); match != nil && match[1] == section {
+			return i + 2
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,36 @@
+package migration
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSQLMigration(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		err     error
+	}{
+		{name: "missing up", content: "-- +down\nDROP TABLE users;\n", line: 2, err: ErrMissingMarker},
+		{name: "missing down", content: "-- +up\nCREATE TABLE users (id INTEGER);\n", line: 2, err: ErrMissingMarker},
+		{name: "duplicate up", content: "-- +up\nSELECT 1;\n-- +down\n-- +up\n", line: 4, err: ErrDuplicateMarker},
+		{name: "sql before marker", content: "\nSELECT 1;\n-- +up\n-- +down\n", line: 2, err: ErrSQLOutsideSections},
+		{name: "directive before marker", content: "-- index\n-- +migrate NoTransaction\n-- +up\n-- +down\n", line: 2, err: ErrDirectiveOutside},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := parseSQLMigration("00001_init.sql", tc.content)
+			require.ErrorIs(t, err, tc.err)
+
+			var parseErr *ParseError
+			require.True(t, errors.As(err, &parseErr))
+			require.Equal(t, "00001_init.sql", parseErr.File)
+			require.Equal(t, tc.line, parseErr.Line)
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"golang.org/x/exp/slices"
+)
+
+type Action int
+
+const (
+	ActionUp Action = iota
+	ActionUpTo
+	ActionDown
+	ActionDownTo
+	ActionRedo
+)
+
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+type PlanStep struct {
+	Version       int64
+	Name          string
+	Direction     string
+	SQL           string
+	Go            bool
+	NoTransaction bool
+}
+
+var (
+	ErrPlan          = errors.New("error plan")
+	ErrUnknownAction = errors.New("unknown action")
+)
+
+// Plan возвращает шаги, которые выполнила бы команда, не изменяя базу и не захватывая блокировку.
+// Версия учитывается только для ActionUpTo и ActionDownTo.
+func (m *migrator) Plan(ctx context.Context, action Action, version int64) ([]PlanStep, error) {
+	var (
+		migrations []*migration
+		directions []string
+		err        error
+	)
+
+	switch action {
+	case ActionUp:
+		migrations, err = m.pendingMigrations(ctx, math.MaxInt64)
+		directions = []string{DirectionUp}
+
+		if err == nil {
+			var repeatable []*migration
+			repeatable, err = m.changedRepeatable(ctx)
+			migrations = append(migrations, repeatable...)
+		}
+	case ActionUpTo:
+		if m.find(version) == nil {
+			return nil, m.logError(ErrPlan, ErrUnknownTargetVersion)
+		}
+
+		migrations, err = m.pendingMigrations(ctx, version)
+		directions = []string{DirectionUp}
+	case ActionDown:
+		migrations, err = m.lastApplied(ctx)
+		directions = []string{DirectionDown}
+	case ActionDownTo:
+		if version != 0 && m.find(version) == nil {
+			return nil, m.logError(ErrPlan, ErrUnknownTargetVersion)
+		}
+
+		migrations, err = m.rollbackMigrations(ctx, version)
+		directions = []string{DirectionDown}
+	case ActionRedo:
+		migrations, err = m.lastApplied(ctx)
+		directions = []string{DirectionDown, DirectionUp}
+	default:
+		err = ErrUnknownAction
+	}
+
+	if err != nil {
+		return nil, m.logError(ErrPlan, err)
+	}
+
+	steps := make([]PlanStep, 0, len(migrations)*len(directions))
+
+	for _, direction := range directions {
+		for _, migration := range migrations {
+			step, err := newPlanStep(migration, direction)
+			if err != nil {
+				return nil, m.logError(ErrPlan, err)
+			}
+
+			steps = append(steps, step)
+		}
+	}
+
+	return steps, nil
+}
+
+func newPlanStep(migration *migration, direction string) (PlanStep, error) {
+	step := PlanStep{
+		Version:   migration.version,
+		Name:      migration.name,
+		Direction: direction,
+		SQL:       migration.up,
+		Go:        migration.upFunc != nil,
+	}
+
+	if direction == DirectionDown {
+		step.SQL = migration.down
+		step.Go = migration.downFunc != nil
+	}
+
+	if !step.Go {
+		d, err := parseDirectives(step.SQL)
+		if err != nil {
+			return PlanStep{}, err
+		}
+
+		step.NoTransaction = d.noTransaction
+	}
+
+	return step, nil
+}
+
+// pendingMigrations возвращает неприменённые миграции до версии target включительно в порядке применения.
+// Миграции ниже последней применённой версии возвращаются, только если разрешён out-of-order.
+func (m *migrator) pendingMigrations(ctx context.Context, target int64) ([]*migration, error) {
+	if err := m.checkDuplicates(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	head := int64(0)
+	unknown := make([]int64, 0)
+
+	for version := range applied {
+		if version > head {
+			head = version
+		}
+
+		if m.find(version) == nil {
+			unknown = append(unknown, version)
+		}
+	}
+
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedMigrationVersion, joinVersions(unknown))
+	}
+
+	if err = m.verifyChecksums(ctx); err != nil {
+		return nil, err
+	}
+
+	m.reportGaps()
+
+	pending := make([]*migration, 0)
+	outOfOrder := make([]int64, 0)
+
+	for i := range m.migrations {
+		version := m.migrations[i].version
+		if applied[version] || version > target {
+			continue
+		}
+
+		if version < head {
+			outOfOrder = append(outOfOrder, version)
+		}
+
+		pending = append(pending, &m.migrations[i])
+	}
+
+	// Повторный запуск up до текущей версии ничего не делает, а цель ниже текущей версии
+	// без пропущенных миграций означает, что откатываться нужно через down.
+	if target < head && len(pending) == 0 {
+		return nil, ErrTargetVersionApplied
+	}
+
+	if len(outOfOrder) > 0 {
+		if !m.allowOutOfOrder {
+			for _, version := range outOfOrder {
+				m.logger.Error(fmt.Sprintf("Migration %d is not applied but the current version is %d", version, head))
+			}
+
+			return nil, fmt.Errorf("%w %d: %s", ErrOutOfOrder, head, joinVersions(outOfOrder))
+		}
+
+		m.logger.Info(fmt.Sprintf("Applying migrations out of order: %s", joinVersions(outOfOrder)))
+	}
+
+	return pending, nil
+}
+
+// appliedVersions возвращает множество версий в статусе "применена". Если процесс упал посреди
+// миграции, её строка осталась в статусе применения или отмены: состояние схемы неизвестно,
+// поэтому возвращается ErrDirty, пока оператор не разберётся и не выполнит force.
+func (m *migrator) appliedVersions(ctx context.Context) (map[int64]bool, error) {
+	migrations, err := m.storage.SelectMigrations(ctx)
+	if err == storage.ErrMigrationNotFound {
+		return map[int64]bool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]bool, len(migrations))
+	dirty := make([]int64, 0)
+
+	for _, migration := range migrations {
+		switch migration.GetStatus() {
+		case storage.StatusSuccess, storage.StatusBaseline:
+			applied[migration.GetVersion()] = true
+		case storage.StatusProcess, storage.StatusCancellation:
+			m.logger.Error(fmt.Sprintf("Migration %d %s is stuck in status %q", migration.GetVersion(),
+				migration.GetName(), migration.GetStatus()))
+			dirty = append(dirty, migration.GetVersion())
+		}
+	}
+
+	if len(dirty) > 0 {
+		slices.Sort(dirty)
+		return nil, fmt.Errorf("%w: %s; check the schema and run force <version> --status applied|rolled-back",
+			ErrDirty, joinVersions(dirty))
+	}
+
+	return applied, nil
+}
+
+// checkDuplicates находит версии, добавленные дважды, например Go- и SQL-миграцию с одним номером.
+func (m *migrator) checkDuplicates() error {
+	duplicates := make([]int64, 0)
+
+	for i := 1; i < len(m.migrations); i++ {
+		if m.migrations[i].version == m.migrations[i-1].version {
+			duplicates = append(duplicates, m.migrations[i].version)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateVersion, joinVersions(duplicates))
+	}
+
+	return nil
+}
+
+// reportGaps логирует пропущенные номера версий: такие миграции могли не попасть в ветку.
+// Между версиями-метками времени пропуски естественны и не логируются.
+func (m *migrator) reportGaps() {
+	previous := int64(0)
+
+	for _, migration := range m.migrations {
+		if IsTimestampVersion(migration.version) {
+			break
+		}
+
+		if migration.version > previous+1 {
+			if migration.version == previous+2 {
+				m.logger.Info(fmt.Sprintf("Gap in migration versions: %d is missing", previous+1))
+			} else {
+				m.logger.Info(fmt.Sprintf("Gap in migration versions: %d-%d are missing", previous+1, migration.version-1))
+			}
+		}
+
+		previous = migration.version
+	}
+}
+
+// rollbackMigrations возвращает применённые миграции с версией больше target в порядке отката.
+func (m *migrator) rollbackMigrations(ctx context.Context, target int64) ([]*migration, error) {
+	lastVersion, err := m.lastVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastVersion < target {
+		return nil, ErrTargetVersionAhead
+	}
+
+	applied, err := m.storage.SelectMigrations(ctx)
+	if err == storage.ErrMigrationNotFound {
+		return []*migration{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rollback := make([]*migration, 0)
+
+	for _, appliedMigration := range applied {
+		if appliedMigration.GetVersion() <= target {
+			continue
+		}
+
+		if appliedMigration.GetStatus() == storage.StatusBaseline {
+			return nil, fmt.Errorf("%w: %d", ErrBaselineRollback, appliedMigration.GetVersion())
+		}
+
+		if appliedMigration.GetStatus() != storage.StatusSuccess {
+			continue
+		}
+
+		migration := m.find(appliedMigration.GetVersion())
+		if migration == nil {
+			return nil, ErrUnexpectedMigrationVersion
+		}
+
+		rollback = append(rollback, migration)
+	}
+
+	return rollback, nil
+}
+
+// lastApplied возвращает последнюю применённую миграцию, которую откатят down и redo.
+func (m *migrator) lastApplied(ctx context.Context) ([]*migration, error) {
+	lastVersion, err := m.lastVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lastMigration, err := m.storage.SelectLastMigrationByStatus(ctx, storage.StatusSuccess)
+	if err != nil && err != storage.ErrMigrationNotFound {
+		return nil, err
+	}
+
+	// Последней может оказаться базовая миграция: её откатывать нечем.
+	if lastMigration == nil || lastMigration.GetVersion() < lastVersion {
+		if lastVersion > 0 {
+			return nil, fmt.Errorf("%w: %d", ErrBaselineRollback, lastVersion)
+		}
+
+		return nil, err
+	}
+
+	last := m.find(lastMigration.GetVersion())
+	if last == nil {
+		return nil, ErrUnexpectedMigrationVersion
+	}
+
+	return []*migration{last}, nil
+}
@@ -0,0 +1,67 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+type MigrateFunc func(ctx context.Context, tx entity.Executor) error
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[int64]migration)
+)
+
+// Register добавляет Go-миграцию, которую подхватит каждый мигратор, созданный после вызова.
+// Обычно вызывается из init() пакета с миграциями. up и down выполняются в транзакции.
+func Register(version int64, name string, up, down MigrateFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if version <= 0 {
+		panic(fmt.Sprintf("migration: invalid version %d for %q", version, name))
+	}
+
+	if registered, ok := registry[version]; ok {
+		panic(fmt.Sprintf("migration: version %d registered twice (%q and %q)", version, registered.name, name))
+	}
+
+	if up == nil {
+		up = noopMigrateFunc
+	}
+
+	if down == nil {
+		down = noopMigrateFunc
+	}
+
+	registry[version] = migration{
+		version:  version,
+		name:     name,
+		upFunc:   up,
+		downFunc: down,
+	}
+}
+
+func registered() []migration {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	migrations := make([]migration, 0, len(registry))
+	for _, m := range registry {
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations
+}
+
+func noopMigrateFunc(context.Context, entity.Executor) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		registry = make(map[int64]migration)
+	}()
+
+	Register(2, "second", nil, nil)
+	Register(1, "first", nil, nil)
+
+	require.Panics(t, func() {
+		Register(1, "duplicate", nil, nil)
+	})
+
+	migrations := registered()
+	require.Len(t, migrations, 2)
+	require.Equal(t, int64(1), migrations[0].version)
+	require.Equal(t, int64(2), migrations[1].version)
+	require.NotNil(t, migrations[0].upFunc)
+	require.NotNil(t, migrations[0].downFunc)
+}
@@ -0,0 +1,77 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+// changedRepeatable возвращает повторяемые миграции, которые ещё не применялись, завершились ошибкой
+// или изменились с последнего успешного применения.
+func (m *migrator) changedRepeatable(ctx context.Context) ([]*migration, error) {
+	applied, err := m.appliedRepeatable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]*migration, 0)
+
+	for i := range m.repeatable {
+		last, ok := applied[m.repeatable[i].name]
+		if ok && last.GetStatus() == storage.StatusSuccess && last.GetChecksum() == m.repeatable[i].checksum {
+			continue
+		}
+
+		changed = append(changed, &m.repeatable[i])
+	}
+
+	return changed, nil
+}
+
+// repeatableStatus возвращает записи статуса повторяемых миграций. ChecksumMismatch означает,
+// что файл изменился и миграция будет применена заново при следующем up.
+func (m *migrator) repeatableStatus(ctx context.Context) ([]StatusRecord, error) {
+	migrations, err := m.storage.SelectRepeatableMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]StatusRecord, 0, len(migrations))
+
+	for _, migration := range migrations {
+		record := newStatusRecord(migration)
+
+		if local := m.findRepeatable(migration.GetName()); local != nil {
+			record.ChecksumMismatch = local.checksum != migration.GetChecksum()
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (m *migrator) appliedRepeatable(ctx context.Context) (map[string]entity.Migration, error) {
+	migrations, err := m.storage.SelectRepeatableMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]entity.Migration, len(migrations))
+	for _, migration := range migrations {
+		applied[migration.GetName()] = migration
+	}
+
+	return applied, nil
+}
+
+func (m *migrator) findRepeatable(name string) *migration {
+	for i := range m.repeatable {
+		if m.repeatable[i].name == name {
+			return &m.repeatable[i]
+		}
+	}
+
+	return nil
+}
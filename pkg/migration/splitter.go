@@ -0,0 +1,378 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// statement — отдельный запрос SQL-миграции и строка текста миграции, с которой он начинается.
+type statement struct {
+	sql  string
+	line int
+}
+
+// StatementError — ошибка отдельного запроса SQL-миграции. Index считается с 1, Line — строка
+// начала запроса в файле File, а если файл неизвестен — в тексте миграции.
+type StatementError struct {
+	Index int
+	File  string
+	Line  int
+	Err   error
+}
+
+const (
+	directiveStatementBegin = "StatementBegin"
+	directiveStatementEnd   = "StatementEnd"
+)
+
+var (
+	ErrUnterminated           = errors.New("unterminated quoted string, comment or dollar-quoted body")
+	ErrMissingStatementEnd    = errors.New("missing -- +migrate " + directiveStatementEnd)
+	ErrUnexpectedStatementEnd = errors.New("unexpected -- +migrate " + directiveStatementEnd)
+)
+
+func (e *StatementError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("statement %d (%s:%d): %s", e.Index, e.File, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("statement %d (line %d): %s", e.Index, e.Line, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// splitStatements делит SQL-миграцию на запросы по точкам с запятой. Точки с запятой внутри строк,
+// идентификаторов в кавычках, комментариев, $$-тел функций, тел BEGIN ATOMIC ... END и
+// CREATE TRIGGER ... BEGIN ... END запрос не завершают. Текст между строками
+// "-- +migrate StatementBegin" и "-- +migrate StatementEnd" выполняется одним запросом.
+// Комментарии и пробелы перед запросом отбрасываются, пустые запросы пропускаются.
+func splitStatements(sql string) ([]statement, error) {
+	s := splitter{runes: []rune(sql), line: 1}
+
+	if err := s.split(); err != nil {
+		return nil, err
+	}
+
+	return s.statements, nil
+}
+
+type splitter struct {
+	runes      []rune
+	statements []statement
+
+	// line — номер строки текущего символа, start — начало текущего запроса.
+	line      int
+	start     int
+	startLine int
+	block     bool
+
+	// words — число слов текущего запроса, depth — вложенность BEGIN ... END и CASE ... END
+	// в теле, create и trigger — запрос начинается с CREATE и создаёт триггер.
+	words   int
+	depth   int
+	create  bool
+	trigger bool
+}
+
+func (s *splitter) split() error {
+	s.startLine = s.line
+
+	for i := 0; i < len(s.runes); i++ {
+		if i == 0 || s.runes[i-1] == '\n' {
+			if directive, end, ok := s.marker(i); ok {
+				if err := s.markerFound(directive, i, end); err != nil {
+					return err
+				}
+
+				i = end - 1
+				continue
+			}
+		}
+
+		next, err := s.skip(i)
+		if err != nil {
+			return err
+		}
+
+		if next > i {
+			i = next - 1
+			continue
+		}
+
+		if s.wordStart(i) {
+			end := s.wordEnd(i)
+			s.keyword(string(s.runes[i:end]), end)
+			i = end - 1
+			continue
+		}
+
+		switch s.runes[i] {
+		case '\n':
+			s.line++
+		case ';':
+			if !s.block && s.depth == 0 {
+				s.emit(i + 1)
+				s.start, s.startLine = i+1, s.line
+			}
+		}
+	}
+
+	if s.block {
+		return fmt.Errorf("%w at line %d", ErrMissingStatementEnd, s.startLine)
+	}
+
+	s.emit(len(s.runes))
+	return nil
+}
+
+// marker проверяет, что строка, начинающаяся с i, — маркер StatementBegin или StatementEnd,
+// и возвращает индекс начала следующей строки.
+func (s *splitter) marker(i int) (string, int, bool) {
+	end := i
+	for end < len(s.runes) && s.runes[end] != '\n' {
+		end++
+	}
+
+	match := regDirective.FindStringSubmatch(strings.TrimSpace(string(s.runes[i:end])))
+	if match == nil || (match[1] != directiveStatementBegin && match[1] != directiveStatementEnd) {
+		return "", 0, false
+	}
+
+	if end < len(s.runes) {
+		end++
+	}
+
+	return match[1], end, true
+}
+
+func (s *splitter) markerFound(directive string, i, end int) error {
+	switch {
+	case directive == directiveStatementBegin && !s.block:
+		s.emit(i)
+		s.block = true
+	case directive == directiveStatementEnd && s.block:
+		s.emit(i)
+		s.block = false
+	case directive == directiveStatementEnd:
+		return fmt.Errorf("%w at line %d", ErrUnexpectedStatementEnd, s.line)
+	default:
+		return fmt.Errorf("%w before line %d", ErrMissingStatementEnd, s.line)
+	}
+
+	if end > 0 && s.runes[end-1] == '\n' {
+		s.line++
+	}
+
+	s.start, s.startLine = end, s.line
+	return nil
+}
+
+// skip пропускает строку, идентификатор в кавычках, комментарий или $$-тело, начинающиеся с i,
+// и возвращает индекс символа после них. Если с i ничего такого не начинается, возвращает i.
+func (s *splitter) skip(i int) (int, error) {
+	var end int
+
+	switch {
+	case s.runes[i] == '\'':
+		end = s.skipQuoted(i+1, '\'', i > 0 && (s.runes[i-1] == 'E' || s.runes[i-1] == 'e') && !s.identRune(i-2))
+	case s.runes[i] == '"':
+		end = s.skipQuoted(i+1, '"', false)
+	case s.runes[i] == '-' && s.at(i+1) == '-':
+		end = s.skipUntil(i+2, "\n")
+		if end < 0 {
+			end = len(s.runes)
+		} else {
+			end-- // перевод строки считается в основном цикле
+		}
+	case s.runes[i] == '/' && s.at(i+1) == '*':
+		end = s.skipBlockComment(i + 2)
+	case s.runes[i] == '$' && !s.identRune(i-1):
+		tag := s.dollarTag(i)
+		if tag == "" {
+			return i, nil
+		}
+
+		end = s.skipUntil(i+utf8.RuneCountInString(tag), tag)
+	default:
+		return i, nil
+	}
+
+	if end < 0 {
+		return 0, fmt.Errorf("%w at line %d", ErrUnterminated, s.line)
+	}
+
+	s.line += strings.Count(string(s.runes[i:end]), "\n")
+	return end, nil
+}
+
+// keyword учитывает слова, от которых зависит конец запроса: тела BEGIN ATOMIC ... END в PostgreSQL
+// и CREATE TRIGGER ... BEGIN ... END в SQLite, как и выражения CASE ... END в них, содержат точки
+// с запятой. next — индекс после слова.
+func (s *splitter) keyword(word string, next int) {
+	word = strings.ToUpper(word)
+
+	switch {
+	case s.words == 0:
+		s.create = word == "CREATE"
+	case word == "TRIGGER" && s.create && s.depth == 0:
+		s.trigger = true
+	case word == "BEGIN" && s.depth == 0:
+		if s.trigger || strings.EqualFold(s.nextWord(next), "ATOMIC") {
+			s.depth++
+		}
+	case word == "CASE" && s.depth > 0:
+		s.depth++
+	case word == "END" && s.depth > 0:
+		s.depth--
+	}
+
+	s.words++
+}
+
+// nextWord возвращает слово после пробелов, начиная с from.
+func (s *splitter) nextWord(from int) string {
+	for from < len(s.runes) && unicode.IsSpace(s.runes[from]) {
+		from++
+	}
+
+	if !s.wordStart(from) {
+		return ""
+	}
+
+	return string(s.runes[from:s.wordEnd(from)])
+}
+
+func (s *splitter) wordStart(i int) bool {
+	return unicode.IsLetter(s.at(i)) && !s.identRune(i-1)
+}
+
+func (s *splitter) wordEnd(i int) int {
+	for i < len(s.runes) && s.identRune(i) {
+		i++
+	}
+
+	return i
+}
+
+// skipQuoted возвращает индекс после закрывающей кавычки. Удвоенная кавычка закрывает и тут же
+// открывает строку заново, поэтому отдельно не обрабатывается. В E-строках кавычку экранирует \.
+func (s *splitter) skipQuoted(from int, quote rune, backslash bool) int {
+	for i := from; i < len(s.runes); i++ {
+		switch {
+		case backslash && s.runes[i] == '\\':
+			i++
+		case s.runes[i] == quote:
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// skipBlockComment учитывает вложенные комментарии, как PostgreSQL.
+func (s *splitter) skipBlockComment(from int) int {
+	depth := 1
+
+	for i := from; i < len(s.runes)-1; i++ {
+		switch {
+		case s.runes[i] == '/' && s.runes[i+1] == '*':
+			depth++
+			i++
+		case s.runes[i] == '*' && s.runes[i+1] == '/':
+			depth--
+			i++
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return -1
+}
+
+// skipUntil возвращает индекс после первого вхождения end, начиная с from, или -1.
+func (s *splitter) skipUntil(from int, end string) int {
+	if from >= len(s.runes) {
+		return -1
+	}
+
+	rest := string(s.runes[from:])
+	if i := strings.Index(rest, end); i >= 0 {
+		return from + utf8.RuneCountInString(rest[:i]) + utf8.RuneCountInString(end)
+	}
+
+	return -1
+}
+
+// dollarTag возвращает тег вида $$ или $name$, начинающийся с i, или пустую строку:
+// $1 — это параметр, а не тег.
+func (s *splitter) dollarTag(i int) string {
+	for j := i + 1; j < len(s.runes); j++ {
+		if s.runes[j] == '$' {
+			return string(s.runes[i : j+1])
+		}
+
+		if s.runes[j] != '_' && !unicode.IsLetter(s.runes[j]) && !(j > i+1 && unicode.IsDigit(s.runes[j])) {
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func (s *splitter) identRune(i int) bool {
+	r := s.at(i)
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (s *splitter) at(i int) rune {
+	if i >= 0 && i < len(s.runes) {
+		return s.runes[i]
+	}
+
+	return 0
+}
+
+// emit добавляет запрос из s.runes[s.start:end], отбросив комментарии и пробелы перед ним.
+func (s *splitter) emit(end int) {
+	start, line := s.start, s.startLine
+	s.words, s.depth, s.create, s.trigger = 0, 0, false, false
+
+	for start < end {
+		switch {
+		case s.runes[start] == '\n':
+			line++
+			start++
+		case unicode.IsSpace(s.runes[start]):
+			start++
+		case s.runes[start] == '-' && s.at(start+1) == '-':
+			for start < end && s.runes[start] != '\n' {
+				start++
+			}
+		case s.runes[start] == '/' && s.at(start+1) == '*':
+			next := s.skipBlockComment(start + 2)
+			if next < 0 || next > end {
+				next = end
+			}
+
+			line += strings.Count(string(s.runes[start:next]), "\n")
+			start = next
+		case s.runes[start] == ';':
+			// Пустой запрос из одной точки с запятой не выполняем.
+			start++
+		default:
+			s.statements = append(s.statements, statement{
+				sql:  strings.TrimRightFunc(string(s.runes[start:end]), unicode.IsSpace),
+				line: line,
+			})
+
+			return
+		}
+	}
+}
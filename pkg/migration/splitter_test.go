@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Run("quotes and comments", func(t *testing.T) {
+		sql := "-- +migrate NoTransaction\n" +
+			"CREATE TABLE a (id INTEGER, \"semi;colon\" TEXT);\n" +
+			"-- comment; with semicolon\n" +
+			"INSERT INTO a VALUES (1, 'x;y''z'), (2, E'\\';');\n" +
+			"/* multi;\n/* nested; */ line */ SELECT\n  1;\n" +
+			"CREATE FUNCTION f() RETURNS void AS $body$\nBEGIN\n  PERFORM 1;\nEND;\n$body$ LANGUAGE plpgsql;\n" +
+			"PREPARE q AS SELECT $1; -- trailing\n"
+
+		statements, err := splitStatements(sql)
+		require.Nil(t, err)
+		require.Equal(t, []statement{
+			{sql: "CREATE TABLE a (id INTEGER, \"semi;colon\" TEXT);", line: 2},
+			{sql: "INSERT INTO a VALUES (1, 'x;y''z'), (2, E'\\';');", line: 4},
+			{sql: "SELECT\n  1;", line: 6},
+			{sql: "CREATE FUNCTION f() RETURNS void AS $body$\nBEGIN\n  PERFORM 1;\nEND;\n$body$ LANGUAGE plpgsql;", line: 8},
+			{sql: "PREPARE q AS SELECT $1;", line: 13},
+		}, statements)
+	})
+
+	t.Run("statement markers", func(t *testing.T) {
+		sql := "SELECT 1;\n" +
+			"-- +migrate StatementBegin\n" +
+			"CREATE RULE r AS ON INSERT TO a DO ALSO (\n  INSERT INTO b VALUES (1);\n  INSERT INTO c VALUES (2)\n);\n" +
+			"-- +migrate StatementEnd\n" +
+			"SELECT 2"
+
+		statements, err := splitStatements(sql)
+		require.Nil(t, err)
+		require.Equal(t, []statement{
+			{sql: "SELECT 1;", line: 1},
+			{sql: "CREATE RULE r AS ON INSERT TO a DO ALSO (\n  INSERT INTO b VALUES (1);\n  INSERT INTO c VALUES (2)\n);", line: 3},
+			{sql: "SELECT 2", line: 8},
+		}, statements)
+	})
+
+	t.Run("begin end bodies", func(t *testing.T) {
+		sql := "CREATE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT 2;\nEND;\n" +
+			"CREATE TRIGGER t AFTER INSERT ON a FOR EACH ROW\nBEGIN\n" +
+			"  UPDATE b SET n = CASE WHEN new.id > 0 THEN 1 ELSE 0 END;\n  DELETE FROM c;\nEND;\n" +
+			"CREATE TRIGGER p BEFORE INSERT ON a FOR EACH ROW EXECUTE FUNCTION f();\n" +
+			"BEGIN;\nSELECT CASE WHEN true THEN 1 END;\nCOMMIT;\n"
+
+		statements, err := splitStatements(sql)
+		require.Nil(t, err)
+		require.Equal(t, []statement{
+			{sql: "CREATE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT 2;\nEND;", line: 1},
+			{
+				sql: "CREATE TRIGGER t AFTER INSERT ON a FOR EACH ROW\nBEGIN\n" +
+					"  UPDATE b SET n = CASE WHEN new.id > 0 THEN 1 ELSE 0 END;\n  DELETE FROM c;\nEND;",
+				line: 6,
+			},
+			{sql: "CREATE TRIGGER p BEFORE INSERT ON a FOR EACH ROW EXECUTE FUNCTION f();", line: 11},
+			{sql: "BEGIN;", line: 12},
+			{sql: "SELECT CASE WHEN true THEN 1 END;", line: 13},
+			{sql: "COMMIT;", line: 14},
+		}, statements)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		statements, err := splitStatements("-- nothing\n\n;\n")
+		require.Nil(t, err)
+		require.Empty(t, statements)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := splitStatements("SELECT 'open;\n")
+		require.ErrorIs(t, err, ErrUnterminated)
+
+		_, err = splitStatements("DO $$ BEGIN END;")
+		require.ErrorIs(t, err, ErrUnterminated)
+
+		_, err = splitStatements("-- +migrate StatementBegin\nSELECT 1;\n")
+		require.ErrorIs(t, err, ErrMissingStatementEnd)
+
+		_, err = splitStatements("SELECT 1;\n-- +migrate StatementEnd\n")
+		require.ErrorIs(t, err, ErrUnexpectedStatementEnd)
+	})
+}
@@ -0,0 +1,32 @@
+package migration
+
+import (
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+type StatusRecord struct {
+	Version          int64
+	Name             string
+	Status           string
+	StatusChangeTime time.Time
+	AppliedTime      time.Time
+	Checksum         string
+	ChecksumMismatch bool
+	Duration         time.Duration
+	Failure          entity.Failure
+}
+
+func newStatusRecord(migration entity.Migration) StatusRecord {
+	return StatusRecord{
+		Version:          migration.GetVersion(),
+		Name:             migration.GetName(),
+		Status:           migration.GetStatus(),
+		StatusChangeTime: migration.GetStatusChangeTime(),
+		AppliedTime:      migration.GetAppliedTime(),
+		Checksum:         migration.GetChecksum(),
+		Duration:         migration.GetDuration(),
+		Failure:          migration.GetFailure(),
+	}
+}
@@ -0,0 +1,62 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var ErrTemplate = errors.New("migration template error")
+
+// Render подставляет переменные vars в секции SQL-миграций как в шаблоны text/template:
+// "GRANT SELECT ON users TO {{ .role }};". Переменная, которой нет в vars, — ошибка.
+// Шаблонами считаются только секции с директивой "-- +migrate Template" в заголовке,
+// остальные, например с литералами массивов '{{1,2},{3,4}}', остаются как есть.
+func Render(migrations []SQLMigration, vars map[string]string) ([]SQLMigration, error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	rendered := make([]SQLMigration, 0, len(migrations))
+
+	for _, m := range migrations {
+		var err error
+
+		if m.Up, err = renderSection(m, "up", m.Up, m.UpSource, vars); err != nil {
+			return nil, err
+		}
+
+		if m.Down, err = renderSection(m, "down", m.Down, m.DownSource, vars); err != nil {
+			return nil, err
+		}
+
+		rendered = append(rendered, m)
+	}
+
+	return rendered, nil
+}
+
+func renderSection(m SQLMigration, section, sql string, source Source, vars map[string]string) (string, error) {
+	// Ошибку в директивах сообщит запуск миграции, а не разбор всех файлов.
+	if d, err := parseDirectives(sql); err != nil || !d.template {
+		return sql, nil
+	}
+
+	name := source.File
+	if name == "" {
+		name = fmt.Sprintf("%d_%s %s", m.Version, m.Name, section)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(sql)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrTemplate, err)
+	}
+
+	var out strings.Builder
+	if err = tmpl.Execute(&out, vars); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrTemplate, err)
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,35 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	migrations := []SQLMigration{
+		{
+			Version: 1, Name: "init",
+			Up:   "-- +migrate Template\nCREATE TABLE {{ .schema }}.users (id INT);\n",
+			Down: "-- +migrate Template\nDROP TABLE {{ .schema }}.users;\n",
+		},
+		{Version: 2, Name: "plain", Up: "INSERT INTO t VALUES ('{{1,2},{3,4}}');\n"},
+	}
+
+	rendered, err := Render(migrations, map[string]string{"schema": "app"})
+	require.Nil(t, err)
+	require.Equal(t, "-- +migrate Template\nCREATE TABLE app.users (id INT);\n", rendered[0].Up)
+	require.Equal(t, "-- +migrate Template\nDROP TABLE app.users;\n", rendered[0].Down)
+	require.Equal(t, migrations[1], rendered[1])
+	require.Equal(t, "-- +migrate Template\nCREATE TABLE {{ .schema }}.users (id INT);\n", migrations[0].Up)
+
+	require.NotEqual(t, checksum(migrations[0].Up, migrations[0].Down), checksum(rendered[0].Up, rendered[0].Down))
+
+	_, err = Render(migrations, nil)
+	require.ErrorIs(t, err, ErrTemplate)
+	require.ErrorContains(t, err, `"schema"`)
+
+	_, err = Render([]SQLMigration{{Version: 3, Name: "broken", Up: "-- +migrate Template\nGRANT ALL TO {{ .role ;", UpSource: Source{File: "00003_broken.sql", Line: 2}}}, nil)
+	require.ErrorIs(t, err, ErrTemplate)
+	require.ErrorContains(t, err, "00003_broken.sql")
+}
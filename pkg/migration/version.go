@@ -0,0 +1,124 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+)
+
+// VersionLayout — формат версий-меток времени YYYYMMDDHHMMSS. Такие версии не совпадают у
+// разработчиков, которые создают миграции в разных ветках.
+const VersionLayout = "20060102150405"
+
+var ErrConvert = errors.New("error convert versions")
+
+// minTimestampVersion — наименьшая 14-значная версия. Всё, что ниже, считается порядковым номером.
+const minTimestampVersion = 10000000000000
+
+// convertBase — метка времени, от которой отсчитываются версии при переводе порядковых номеров:
+// номер N становится convertBase + N секунд. Отображение не зависит от момента запуска, поэтому
+// одинаково переводит файлы и записи в любой базе, и все такие версии меньше новых меток времени.
+var convertBase = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// IsTimestampVersion сообщает, что версия записана в формате VersionLayout.
+func IsTimestampVersion(version int64) bool {
+	return version >= minTimestampVersion
+}
+
+// NewTimestampVersion возвращает версию для новой миграции: текущее время в UTC,
+// но не меньше следующей секунды после last, если last — тоже метка времени.
+func NewTimestampVersion(now time.Time, last int64) int64 {
+	now = now.UTC().Truncate(time.Second)
+
+	if IsTimestampVersion(last) {
+		if previous, err := parseTimestampVersion(last); err == nil && !now.After(previous) {
+			now = previous.Add(time.Second)
+		}
+	}
+
+	return formatTimestampVersion(now)
+}
+
+// TimestampVersion переводит порядковый номер в версию-метку времени. Метки времени не меняются.
+func TimestampVersion(version int64) int64 {
+	if IsTimestampVersion(version) {
+		return version
+	}
+
+	return formatTimestampVersion(convertBase.Add(time.Duration(version) * time.Second))
+}
+
+func formatTimestampVersion(t time.Time) int64 {
+	version, _ := strconv.ParseInt(t.Format(VersionLayout), 10, 64)
+	return version
+}
+
+func parseTimestampVersion(version int64) (time.Time, error) {
+	return time.Parse(VersionLayout, strconv.FormatInt(version, 10))
+}
+
+// ConvertVersions переводит порядковые версии записей в базе в метки времени тем же
+// отображением, что и TimestampVersion. Старые записи журнала остаются под прежними версиями,
+// а перевод каждой версии добавляется в журнал. Повторный запуск ничего не меняет.
+func (m *migrator) ConvertVersions(ctx context.Context) ([]StatusRecord, error) {
+	m.logger.Info("Convert versions start")
+
+	err := m.withLock(ctx, ErrConvert, func() error {
+		migrations, err := m.storage.SelectMigrations(ctx)
+		if err == storage.ErrMigrationNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		records := make([]StatusRecord, 0)
+
+		err = m.storage.Transaction(ctx, func(tx storage.Storage) error {
+			for i := len(migrations) - 1; i >= 0; i-- {
+				version := migrations[i].GetVersion()
+				if IsTimestampVersion(version) {
+					continue
+				}
+
+				converted := TimestampVersion(version)
+
+				m.logger.Info(fmt.Sprintf("Convert version %d to %d", version, converted))
+				if err := tx.UpdateVersion(ctx, version, converted); err != nil {
+					return err
+				}
+
+				migrations[i].SetVersion(converted)
+
+				history := m.newHistory(migrations[i], nil)
+				history.Status = storage.StatusConverted
+				history.Time = time.Now()
+				history.Duration = 0
+				history.Error = fmt.Sprintf("converted from version %d", version)
+
+				if err := tx.InsertHistory(ctx, history); err != nil {
+					return err
+				}
+
+				records = append(records, newStatusRecord(migrations[i]))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		m.processed = append(m.processed, records...)
+		return nil
+	})
+	if err != nil {
+		return m.processed, err
+	}
+
+	m.logger.Info("Convert versions end")
+	return m.processed, nil
+}
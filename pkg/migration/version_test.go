@@ -0,0 +1,64 @@
+package migration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampVersion(t *testing.T) {
+	require.Equal(t, int64(20000101000001), TimestampVersion(1))
+	require.Equal(t, int64(20000102000000), TimestampVersion(86400))
+	require.Equal(t, int64(20221001120000), TimestampVersion(20221001120000))
+	require.False(t, IsTimestampVersion(99999))
+
+	now := time.Date(2022, 10, 1, 12, 0, 0, 500, time.FixedZone("MSK", 3*60*60))
+	require.Equal(t, int64(20221001090000), NewTimestampVersion(now, 7))
+	require.Equal(t, int64(20221001090000), NewTimestampVersion(now, 20221001085959))
+	require.Equal(t, int64(20221001090001), NewTimestampVersion(now, 20221001090000))
+	require.Equal(t, int64(20221001100000), NewTimestampVersion(now, 20221001095959))
+}
+
+func TestMigratorConvertVersions(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	m := newTestMigrator(store)
+
+	m.Create(1, "first", "SELECT 1;", "")
+	m.Create(2, "second", "SELECT 2;", "")
+	require.Nil(t, onlyErr(m.Up(ctx)))
+
+	records, err := m.ConvertVersions(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, int64(20000101000001), records[0].Version)
+	require.Equal(t, storage.StatusSuccess, store.status(20000101000002))
+	require.Equal(t, int64(1), store.history[0].Version)
+
+	history, err := m.History(ctx, 20000101000001)
+	require.Nil(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, storage.StatusConverted, history[0].Status)
+	require.Equal(t, "converted from version 1", history[0].Error)
+
+	records, err = m.ConvertVersions(ctx)
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	converted := newTestMigrator(store)
+	converted.Create(20000101000001, "first", "SELECT 1;", "")
+	converted.Create(20000101000002, "second", "SELECT 2;", "")
+	converted.Create(20221001120000, "third", "SELECT 3;", "")
+
+	records, err = converted.Up(ctx)
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, storage.StatusSuccess, store.status(20221001120000))
+
+	require.Nil(t, store.InsertMigration(ctx, entity.NewMigration("old", storage.StatusSuccess, 3, time.Now())))
+	require.ErrorIs(t, onlyErr(converted.Up(ctx)), ErrUnexpectedMigrationVersion)
+}
@@ -0,0 +1,20 @@
+package entity
+
+import "context"
+
+type Executor interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) error
+	Query(ctx context.Context, sql string, args ...interface{}) (Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) Row
+}
+
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close()
+}
+
+type Row interface {
+	Scan(dest ...interface{}) error
+}
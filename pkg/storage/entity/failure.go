@@ -0,0 +1,14 @@
+package entity
+
+// Failure — подробности ошибки, с которой завершилась миграция. Пустой Message означает,
+// что ошибки не было. Line — строка, с которой начинается упавший запрос: в файле File,
+// а если файл неизвестен — в тексте миграции.
+type Failure struct {
+	Code     string
+	Message  string
+	Detail   string
+	Hint     string
+	Position int
+	File     string
+	Line     int
+}
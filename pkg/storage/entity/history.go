@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+// History — запись журнала переходов статусов миграции.
+type History struct {
+	Version     int64
+	Name        string
+	Status      string
+	Time        time.Time
+	Duration    time.Duration
+	Hostname    string
+	User        string
+	ToolVersion string
+	Error       string
+}
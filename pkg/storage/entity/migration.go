@@ -5,24 +5,36 @@ import "time"
 type Migration interface {
 	GetName() string
 	GetStatus() string
-	GetVersion() int
+	GetVersion() int64
 	GetStatusChangeTime() time.Time
+	GetAppliedTime() time.Time
+	GetDuration() time.Duration
+	GetChecksum() string
+	GetFailure() Failure
 
 	SetName(name string)
 	SetStatus(status string)
-	SetVersion(version int)
+	SetVersion(version int64)
 	SetStatusChangeTime(statusChangeTime time.Time)
+	SetAppliedTime(appliedTime time.Time)
+	SetDuration(duration time.Duration)
+	SetChecksum(checksum string)
+	SetFailure(failure Failure)
 }
 
 type migration struct {
 	Name    string
-	Version int
+	Version int64
 
 	Status           string
 	StatusChangeTime time.Time
+	AppliedTime      time.Time
+	Duration         time.Duration
+	Checksum         string
+	Failure          Failure
 }
 
-func NewMigration(name, status string, version int, statusChangeTime time.Time) Migration {
+func NewMigration(name, status string, version int64, statusChangeTime time.Time) Migration {
 	return &migration{
 		Name:             name,
 		Status:           status,
@@ -39,7 +51,7 @@ func (m *migration) GetStatus() string {
 	return m.Status
 }
 
-func (m *migration) GetVersion() int {
+func (m *migration) GetVersion() int64 {
 	return m.Version
 }
 
@@ -47,6 +59,22 @@ func (m *migration) GetStatusChangeTime() time.Time {
 	return m.StatusChangeTime
 }
 
+func (m *migration) GetAppliedTime() time.Time {
+	return m.AppliedTime
+}
+
+func (m *migration) GetDuration() time.Duration {
+	return m.Duration
+}
+
+func (m *migration) GetChecksum() string {
+	return m.Checksum
+}
+
+func (m *migration) GetFailure() Failure {
+	return m.Failure
+}
+
 func (m *migration) SetName(name string) {
 	m.Name = name
 }
@@ -55,10 +83,26 @@ func (m *migration) SetStatus(status string) {
 	m.Status = status
 }
 
-func (m *migration) SetVersion(version int) {
+func (m *migration) SetVersion(version int64) {
 	m.Version = version
 }
 
 func (m *migration) SetStatusChangeTime(statusChangeTime time.Time) {
 	m.StatusChangeTime = statusChangeTime
 }
+
+func (m *migration) SetAppliedTime(appliedTime time.Time) {
+	m.AppliedTime = appliedTime
+}
+
+func (m *migration) SetDuration(duration time.Duration) {
+	m.Duration = duration
+}
+
+func (m *migration) SetChecksum(checksum string) {
+	m.Checksum = checksum
+}
+
+func (m *migration) SetFailure(failure Failure) {
+	m.Failure = failure
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "errors"
+
+// DBError — ошибка базы данных с подробностями, которые драйвер смог извлечь из ответа сервера.
+// Position — номер символа в тексте запроса, начиная с 1, или 0, если сервер его не сообщил.
+type DBError struct {
+	Code     string
+	Message  string
+	Detail   string
+	Hint     string
+	Position int
+
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// CodeLockNotAvailable — SQLSTATE ошибки ожидания блокировки дольше lock_timeout.
+const CodeLockNotAvailable = "55P03"
+
+// IsLockTimeout сообщает, что запрос не дождался блокировки.
+func IsLockTimeout(err error) bool {
+	var dbErr *DBError
+	return errors.As(err, &dbErr) && dbErr.Code == CodeLockNotAvailable
+}
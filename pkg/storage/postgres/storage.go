@@ -4,82 +4,267 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"strings"
 	"time"
 
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
 	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 )
 
-type Storage interface {
-	SelectMigrations(context.Context) ([]entity.Migration, error)
-	SelectLastMigrationByStatus(context.Context, string) (entity.Migration, error)
-	Connect(context.Context) error
-	Close(context.Context) error
-	InsertMigration(context.Context, entity.Migration) error
-	Migrate(context.Context, string) error
-	DeleteMigrations(context.Context) error
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 }
 
 type sqlStorage struct {
 	connString string
 	conn       *pgx.Conn
+	tx         pgx.Tx
+	db         querier
+	schema     string
+	table      string
+	history    string
+	versionKey string
+	lockKey    int64
+
+	// versionIndex — имя индекса versionKey со схемой, versionKeyName — без экранирования.
+	versionIndex   string
+	versionKeyName string
+	repeatableKey  string
+
+	// columns — список столбцов для чтения миграций, missing — таблицы миграций ещё нет.
+	columns string
+	missing bool
 }
 
-const (
-	StatusProcess      = "применение"
-	StatusSuccess      = "применена"
-	StatusError        = "ошибка"
-	StatusCancellation = "отмена"
-	StatusCancel       = "отменена"
-)
+func init() {
+	storage.Register("postgres", New)
+	storage.Register("postgresql", New)
+}
 
-var (
-	ErrUnexpectedStatus  = errors.New("err unexpected status")
-	ErrMigrationNotFound = errors.New("migration not found")
-)
+func New(connString string, opts ...storage.Option) storage.Storage {
+	config := storage.NewConfig(opts...)
 
-func New(connString string) Storage {
-	return &sqlStorage{
+	s := &sqlStorage{
 		connString: connString,
+		table:      pgx.Identifier{config.Table}.Sanitize(),
+		history:    pgx.Identifier{config.HistoryTable()}.Sanitize(),
+		versionKey: pgx.Identifier{config.Table + "_version_key"}.Sanitize(),
+		lockKey:    int64(crc32.ChecksumIEEE([]byte(config.QualifiedTable()))),
+
+		versionKeyName: config.Table + "_version_key",
+		repeatableKey:  pgx.Identifier{config.Table + "_repeatable_key"}.Sanitize(),
+	}
+
+	s.versionIndex = s.versionKey
+
+	if config.Schema != "" {
+		s.schema = pgx.Identifier{config.Schema}.Sanitize()
+		s.table = pgx.Identifier{config.Schema, config.Table}.Sanitize()
+		s.history = pgx.Identifier{config.Schema, config.HistoryTable()}.Sanitize()
+		s.versionIndex = pgx.Identifier{config.Schema, s.versionKeyName}.Sanitize()
 	}
+
+	return s
 }
 
-func (storage *sqlStorage) Connect(ctx context.Context) error {
-	conn, err := pgx.Connect(ctx, storage.connString)
+func (s *sqlStorage) Connect(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, s.connString)
 	if err != nil {
 		return err
 	}
 
-	sql := `
-		CREATE TABLE IF NOT EXISTS schema_migrations (
-			Version INTEGER,
+	s.conn = conn
+	s.db = conn
+
+	if err = s.readColumns(ctx); err != nil {
+		_ = conn.Close(ctx)
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) Init(ctx context.Context) (err error) {
+	conn := s.conn
+
+	if s.schema != "" {
+		if _, err = conn.Exec(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, s.schema)); err != nil {
+			return err
+		}
+	}
+
+	// Таблицы, созданные до появления уникального ключа, обновляются: из дублей версии
+	// остаётся последняя по времени запись, после чего создаётся уникальный индекс.
+	sql := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			Version BIGINT NOT NULL,
+			Name CHARACTER VARYING(100),
+			Status CHARACTER VARYING(20),
+			StatusChangeTime TIMESTAMP,
+			AppliedTime TIMESTAMP,
+			Duration BIGINT,
+			Checksum CHARACTER VARYING(64),
+			ErrorCode CHARACTER VARYING(5),
+			ErrorMessage TEXT,
+			ErrorDetail TEXT,
+			ErrorHint TEXT,
+			ErrorPosition INTEGER,
+			ErrorFile CHARACTER VARYING(255),
+			ErrorLine INTEGER
+		);
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS AppliedTime TIMESTAMP;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS Duration BIGINT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS Checksum CHARACTER VARYING(64);
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorCode CHARACTER VARYING(5);
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorMessage TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorDetail TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorHint TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorPosition INTEGER;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorFile CHARACTER VARYING(255);
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS ErrorLine INTEGER;
+		DELETE FROM %[1]s older USING %[1]s newer
+		WHERE older.Version = newer.Version AND older.%[5]s
+			AND (COALESCE(older.StatusChangeTime, '-infinity'), older.ctid)
+				< (COALESCE(newer.StatusChangeTime, '-infinity'), newer.ctid);
+		CREATE UNIQUE INDEX IF NOT EXISTS %[2]s ON %[1]s %[6]s;
+		CREATE UNIQUE INDEX IF NOT EXISTS %[4]s ON %[1]s %[7]s;
+		CREATE TABLE IF NOT EXISTS %[3]s (
+			ID BIGSERIAL PRIMARY KEY,
+			Version BIGINT NOT NULL,
 			Name CHARACTER VARYING(100),
 			Status CHARACTER VARYING(20),
-			StatusChangeTime TIMESTAMP
-		);`
+			ChangeTime TIMESTAMP,
+			Duration BIGINT,
+			Hostname CHARACTER VARYING(255),
+			OsUser CHARACTER VARYING(255),
+			ToolVersion CHARACTER VARYING(100),
+			Error TEXT
+		);`, s.table, s.versionKey, s.history, s.repeatableKey,
+		storage.VersionedCondition, storage.VersionKey, storage.RepeatableKey)
 
 	_, err = conn.Exec(ctx, sql)
 	if err != nil {
 		return err
 	}
 
-	storage.conn = conn
+	// Версии-метки времени YYYYMMDDHHMMSS не помещаются в INTEGER, поэтому столбцы Version
+	// таблиц, созданных раньше, расширяются до BIGINT.
+	for _, table := range []string{s.table, s.history} {
+		var dataType string
+
+		err = conn.QueryRow(ctx, `
+			SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+			WHERE attrelid = $1::regclass AND attname = 'version';`, table).Scan(&dataType)
+		if err != nil {
+			return err
+		}
+
+		if dataType == "integer" {
+			if _, err = conn.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN Version TYPE BIGINT;`, table)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = s.partialVersionKey(ctx, conn); err != nil {
+		return err
+	}
+
+	return s.readColumns(ctx)
+}
+
+// readColumns запоминает, какие столбцы есть в таблице миграций, чтобы до Init читать таблицу
+// в виде, оставленном прошлой версией, или считать пустой, если её нет.
+func (s *sqlStorage) readColumns(ctx context.Context) error {
+	rows, err := s.conn.Query(ctx, `
+		SELECT lower(attname) FROM pg_attribute
+		WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped;`, s.table)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return err
+		}
+
+		columns[column] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	s.missing = len(columns) == 0
+	s.columns = storage.SelectColumns(func(column string) bool { return columns[strings.ToLower(column)] })
+
 	return nil
 }
 
-func (storage *sqlStorage) Close(ctx context.Context) error {
-	return storage.conn.Close(ctx)
+// partialVersionKey заменяет уникальный ключ версии в таблицах, созданных до повторяемых миграций,
+// на частичный индекс: у всех повторяемых миграций версия RepeatableVersion.
+func (s *sqlStorage) partialVersionKey(ctx context.Context, conn *pgx.Conn) error {
+	var partial bool
+
+	err := conn.QueryRow(ctx, `
+		SELECT i.indpred IS NOT NULL FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::regclass AND c.relname = $2;`, s.table, s.versionKeyName).Scan(&partial)
+	if err != nil || partial {
+		return err
+	}
+
+	_, err = conn.Exec(ctx, fmt.Sprintf(`
+		ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[2]s;
+		DROP INDEX IF EXISTS %[3]s;
+		CREATE UNIQUE INDEX %[2]s ON %[1]s %[4]s;`, s.table, s.versionKey, s.versionIndex, storage.VersionKey))
+
+	return err
+}
+
+func (s *sqlStorage) Close(ctx context.Context) error {
+	return s.conn.Close(ctx)
 }
 
-func (storage *sqlStorage) DeleteMigrations(ctx context.Context) error {
-	_, err := storage.conn.Exec(ctx, "TRUNCATE schema_migrations;")
+func (s *sqlStorage) DeleteMigrations(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, fmt.Sprintf("TRUNCATE %s;", s.table))
 	return err
 }
 
-func (storage *sqlStorage) SelectMigrations(ctx context.Context) (migrations []entity.Migration, err error) {
-	sql := `SELECT Name, Status, Version, StatusChangeTime FROM schema_migrations ORDER BY Version DESC;`
+func (s *sqlStorage) SelectMigrations(ctx context.Context) ([]entity.Migration, error) {
+	migrations, err := s.selectMigrations(ctx, storage.VersionedCondition+" ORDER BY Version DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) == 0 {
+		return nil, storage.ErrMigrationNotFound
+	}
+
+	return migrations, nil
+}
+
+func (s *sqlStorage) SelectRepeatableMigrations(ctx context.Context) ([]entity.Migration, error) {
+	return s.selectMigrations(ctx, storage.RepeatableCondition+" ORDER BY Name")
+}
+
+func (s *sqlStorage) selectMigrations(ctx context.Context, condition string) (migrations []entity.Migration, err error) {
+	if s.missing {
+		return nil, nil
+	}
+
+	sql := fmt.Sprintf(`SELECT %s FROM %s WHERE %s;`, s.columns, s.table, condition)
 
-	rows, err := storage.conn.Query(ctx, sql)
+	rows, err := s.db.Query(ctx, sql)
 	if err != nil {
 		return nil, err
 	}
@@ -87,92 +272,307 @@ func (storage *sqlStorage) SelectMigrations(ctx context.Context) (migrations []e
 	defer rows.Close()
 
 	for rows.Next() {
-		var (
-			name             string
-			version          int
-			status           string
-			statusChangeTime time.Time
-		)
-
-		err = rows.Scan(&name, &status, &version, &statusChangeTime)
+		migration, err := scanMigration(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		migrations = append(migrations, entity.NewMigration(name, status, version, statusChangeTime))
-	}
-
-	if len(migrations) == 0 {
-		return nil, ErrMigrationNotFound
+		migrations = append(migrations, migration)
 	}
 
-	return migrations, nil
+	return migrations, rows.Err()
 }
 
-func (storage *sqlStorage) SelectLastMigrationByStatus(ctx context.Context, status string) (migration entity.Migration, err error) {
+func (s *sqlStorage) SelectLastMigrationByStatus(ctx context.Context, status string) (migration entity.Migration, err error) {
 	switch status {
-	case StatusSuccess:
-	case StatusError:
-	case StatusProcess:
-	case StatusCancellation:
-	case StatusCancel:
+	case storage.StatusSuccess:
+	case storage.StatusError:
+	case storage.StatusProcess:
+	case storage.StatusCancellation:
+	case storage.StatusCancel:
+	case storage.StatusBaseline:
 	default:
-		return nil, ErrUnexpectedStatus
+		return nil, storage.ErrUnexpectedStatus
+	}
+
+	if s.missing {
+		return nil, storage.ErrMigrationNotFound
+	}
+
+	sql := fmt.Sprintf(`SELECT %s FROM %s WHERE Status = $1 AND %s ORDER BY Version DESC LIMIT 1;`,
+		s.columns, s.table, storage.VersionedCondition)
+
+	migration, err = scanMigration(s.db.QueryRow(ctx, sql, status))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrMigrationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return migration, nil
+}
+
+func scanMigration(row pgx.Row) (entity.Migration, error) {
+	var (
+		name             string
+		version          int64
+		status           string
+		statusChangeTime time.Time
+		appliedTime      *time.Time
+		duration         int64
+		checksum         string
+		failure          entity.Failure
+	)
+
+	err := row.Scan(&name, &status, &version, &statusChangeTime, &appliedTime, &duration, &checksum,
+		&failure.Code, &failure.Message, &failure.Detail, &failure.Hint, &failure.Position, &failure.File, &failure.Line)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := entity.NewMigration(name, status, version, statusChangeTime)
+	migration.SetDuration(time.Duration(duration) * time.Millisecond)
+	migration.SetChecksum(checksum)
+	migration.SetFailure(failure)
+
+	if appliedTime != nil {
+		migration.SetAppliedTime(*appliedTime)
 	}
 
-	sql := `SELECT Name, Status, Version, StatusChangeTime FROM schema_migrations WHERE Status = $1 ORDER BY Version DESC LIMIT 1;`
+	return migration, nil
+}
+
+func (s *sqlStorage) InsertMigration(ctx context.Context, migration entity.Migration) error {
+	var appliedTime *time.Time
+	if !migration.GetAppliedTime().IsZero() {
+		t := migration.GetAppliedTime()
+		appliedTime = &t
+	}
 
-	rows, err := storage.conn.Query(ctx, sql, status)
+	sql := fmt.Sprintf(`
+		INSERT INTO %s (Version, Name, Status, StatusChangeTime, AppliedTime, Duration, Checksum,
+			ErrorCode, ErrorMessage, ErrorDetail, ErrorHint, ErrorPosition, ErrorFile, ErrorLine)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''),
+			NULLIF($12, 0), NULLIF($13, ''), NULLIF($14, 0))
+		ON CONFLICT %s DO UPDATE SET
+			Name = EXCLUDED.Name,
+			Status = EXCLUDED.Status,
+			StatusChangeTime = EXCLUDED.StatusChangeTime,
+			AppliedTime = EXCLUDED.AppliedTime,
+			Duration = EXCLUDED.Duration,
+			Checksum = EXCLUDED.Checksum,
+			ErrorCode = EXCLUDED.ErrorCode,
+			ErrorMessage = EXCLUDED.ErrorMessage,
+			ErrorDetail = EXCLUDED.ErrorDetail,
+			ErrorHint = EXCLUDED.ErrorHint,
+			ErrorPosition = EXCLUDED.ErrorPosition,
+			ErrorFile = EXCLUDED.ErrorFile,
+			ErrorLine = EXCLUDED.ErrorLine;`, s.table, storage.ConflictTarget(migration))
+
+	failure := migration.GetFailure()
+
+	_, err := s.db.Exec(ctx, sql,
+		migration.GetVersion(), migration.GetName(), migration.GetStatus(), migration.GetStatusChangeTime(),
+		appliedTime, migration.GetDuration().Milliseconds(), migration.GetChecksum(),
+		failure.Code, failure.Message, failure.Detail, failure.Hint, failure.Position, failure.File, failure.Line)
+
+	return err
+}
+
+func (s *sqlStorage) UpdateChecksum(ctx context.Context, version int64, checksum string) error {
+	sql := fmt.Sprintf(`UPDATE %s SET Checksum = $1 WHERE Version = $2;`, s.table)
+
+	_, err := s.db.Exec(ctx, sql, checksum, version)
+	return err
+}
+
+func (s *sqlStorage) UpdateVersion(ctx context.Context, from, to int64) error {
+	sql := fmt.Sprintf(`UPDATE %s SET Version = $2 WHERE Version = $1;`, s.table)
+
+	_, err := s.db.Exec(ctx, sql, from, to)
+	return err
+}
+
+func (s *sqlStorage) InsertHistory(ctx context.Context, history entity.History) error {
+	sql := fmt.Sprintf(`
+		INSERT INTO %s (Version, Name, Status, ChangeTime, Duration, Hostname, OsUser, ToolVersion, Error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`, s.history)
+
+	_, err := s.db.Exec(ctx, sql, history.Version, history.Name, history.Status, history.Time,
+		history.Duration.Milliseconds(), history.Hostname, history.User, history.ToolVersion, history.Error)
+
+	return err
+}
+
+func (s *sqlStorage) SelectHistory(ctx context.Context, version int64) ([]entity.History, error) {
+	sql := fmt.Sprintf(`
+		SELECT Version, Name, Status, ChangeTime, Duration, Hostname, OsUser, ToolVersion, Error
+		FROM %s WHERE $1::bigint = 0 OR Version = $1::bigint ORDER BY ID;`, s.history)
+
+	rows, err := s.db.Query(ctx, sql, version)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	if rows.Next() {
+	history := make([]entity.History, 0)
+
+	for rows.Next() {
 		var (
-			name             string
-			version          int
-			status           string
-			statusChangeTime time.Time
+			entry    entity.History
+			duration int64
 		)
 
-		err = rows.Scan(&name, &status, &version, &statusChangeTime)
+		err = rows.Scan(&entry.Version, &entry.Name, &entry.Status, &entry.Time, &duration,
+			&entry.Hostname, &entry.User, &entry.ToolVersion, &entry.Error)
 		if err != nil {
 			return nil, err
 		}
 
-		migration = entity.NewMigration(name, status, version, statusChangeTime)
-	} else {
-		return nil, ErrMigrationNotFound
+		entry.Duration = time.Duration(duration) * time.Millisecond
+		history = append(history, entry)
 	}
 
-	return migration, nil
+	return history, rows.Err()
 }
 
-func (storage *sqlStorage) InsertMigration(ctx context.Context, migration entity.Migration) (err error) {
-	sql := fmt.Sprintf(`
-		DO $$ BEGIN
-			IF EXISTS (SELECT * FROM schema_migrations WHERE Version = %d AND Name = '%s') THEN
-				UPDATE schema_migrations SET Status = '%s', StatusChangeTime = '%s' WHERE Version = %d AND Name = '%s';
-			ELSE
-				INSERT INTO schema_migrations (Version, Name, Status, StatusChangeTime)
-				VALUES (%d, '%s', '%s', '%s');
-			END IF;
-		END $$;`,
-		migration.GetVersion(), migration.GetName(),
-		migration.GetStatus(), migration.GetStatusChangeTime().Format("2006-01-02 15:04:05"), migration.GetVersion(), migration.GetName(),
-		migration.GetVersion(), migration.GetName(), migration.GetStatus(), migration.GetStatusChangeTime().Format("2006-01-02 15:04:05"))
-
-	_, err = storage.conn.Exec(ctx, sql)
+func (s *sqlStorage) Migrate(ctx context.Context, sql string) (err error) {
+	_, err = s.db.Exec(ctx, sql)
+	return dbError(err)
+}
+
+// SetTimeouts задаёт statement_timeout и lock_timeout: в транзакции через SET LOCAL, чтобы
+// значения сбросились вместе с ней. Нулевое значение возвращает настройку по умолчанию.
+func (s *sqlStorage) SetTimeouts(ctx context.Context, timeouts storage.Timeouts) error {
+	scope := "SESSION"
+	if s.tx != nil {
+		scope = "LOCAL"
+	}
+
+	sql := fmt.Sprintf(`SET %[1]s statement_timeout = %[2]s; SET %[1]s lock_timeout = %[3]s;`,
+		scope, timeoutValue(timeouts.Statement), timeoutValue(timeouts.Lock))
+
+	_, err := s.db.Exec(ctx, sql)
+	return err
+}
+
+func timeoutValue(timeout time.Duration) string {
+	if timeout <= 0 {
+		return "DEFAULT"
+	}
+
+	// Доли миллисекунды округляются вверх: '0ms' сняло бы ограничение.
+	ms := timeout.Milliseconds()
+	if ms == 0 {
+		ms = 1
+	}
+
+	return fmt.Sprintf("'%dms'", ms)
+}
+
+// dbError переносит подробности ошибки PostgreSQL в storage.DBError.
+func dbError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	return &storage.DBError{
+		Code:     pgErr.Code,
+		Message:  pgErr.Message,
+		Detail:   pgErr.Detail,
+		Hint:     pgErr.Hint,
+		Position: int(pgErr.Position),
+		Err:      err,
+	}
+}
+
+func (s *sqlStorage) TryLock(ctx context.Context) (locked bool, err error) {
+	err = s.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1);`, s.lockKey).Scan(&locked)
+	return locked, err
+}
+
+func (s *sqlStorage) Unlock(ctx context.Context) error {
+	var unlocked bool
+
+	err := s.conn.QueryRow(ctx, `SELECT pg_advisory_unlock($1);`, s.lockKey).Scan(&unlocked)
 	if err != nil {
 		return err
 	}
 
+	if !unlocked {
+		return storage.ErrLockNotHeld
+	}
+
 	return nil
 }
 
-func (storage *sqlStorage) Migrate(ctx context.Context, sql string) (err error) {
-	_, err = storage.conn.Exec(ctx, sql)
-	return err
+func (s *sqlStorage) LockHolder(ctx context.Context) (pid int, err error) {
+	sql := `
+		SELECT pid FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND classid = 0 AND objid::bigint = $1 AND objsubid = 1
+		LIMIT 1;`
+
+	err = s.conn.QueryRow(ctx, sql, s.lockKey).Scan(&pid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, storage.ErrLockHolderUnknown
+	}
+
+	return pid, err
+}
+
+func (s *sqlStorage) Transaction(ctx context.Context, fn func(storage.Storage) error) (err error) {
+	var tx pgx.Tx
+
+	if s.tx != nil {
+		tx, err = s.tx.Begin(ctx)
+	} else {
+		tx, err = s.conn.Begin(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = fn(&sqlStorage{
+		connString: s.connString,
+		conn:       s.conn,
+		tx:         tx,
+		db:         tx,
+		schema:     s.schema,
+		table:      s.table,
+		history:    s.history,
+		versionKey: s.versionKey,
+		lockKey:    s.lockKey,
+
+		versionIndex:   s.versionIndex,
+		versionKeyName: s.versionKeyName,
+		repeatableKey:  s.repeatableKey,
+
+		columns: s.columns,
+		missing: s.missing,
+	})
+	if err != nil {
+		if errRollback := tx.Rollback(ctx); errRollback != nil && !errors.Is(errRollback, pgx.ErrTxClosed) {
+			return fmt.Errorf("%w (rollback: %s)", err, errRollback)
+		}
+
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *sqlStorage) Exec(ctx context.Context, sql string, args ...interface{}) error {
+	_, err := s.db.Exec(ctx, sql, args...)
+	return dbError(err)
+}
+
+func (s *sqlStorage) Query(ctx context.Context, sql string, args ...interface{}) (entity.Rows, error) {
+	return s.db.Query(ctx, sql, args...)
+}
+
+func (s *sqlStorage) QueryRow(ctx context.Context, sql string, args ...interface{}) entity.Row {
+	return s.db.QueryRow(ctx, sql, args...)
 }
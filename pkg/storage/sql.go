@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+// VersionedCondition и RepeatableCondition отбирают строки версионных и повторяемых миграций:
+// повторяемые хранятся с версией RepeatableVersion.
+var (
+	VersionedCondition  = fmt.Sprintf("Version <> %d", RepeatableVersion)
+	RepeatableCondition = fmt.Sprintf("Version = %d", RepeatableVersion)
+)
+
+// VersionKey и RepeatableKey — столбцы и условия частичных уникальных индексов таблицы миграций.
+var (
+	VersionKey    = "(Version) WHERE " + VersionedCondition
+	RepeatableKey = "(Name) WHERE " + RepeatableCondition
+)
+
+// migrationColumns — столбцы, которые драйверы читают из таблицы миграций, в порядке сканирования.
+// fallback читается вместо столбца, которого нет в таблице, созданной прошлой версией, а при
+// coalesce заменяет и NULL. Столбцы без fallback были в таблице всегда.
+var migrationColumns = []struct {
+	name     string
+	fallback string
+	coalesce bool
+}{
+	{name: "Name"},
+	{name: "Status"},
+	{name: "Version"},
+	{name: "StatusChangeTime"},
+	{name: "AppliedTime", fallback: "CAST(NULL AS TIMESTAMP)"},
+	{name: "Duration", fallback: "0", coalesce: true},
+	{name: "Checksum", fallback: "''", coalesce: true},
+	{name: "ErrorCode", fallback: "''", coalesce: true},
+	{name: "ErrorMessage", fallback: "''", coalesce: true},
+	{name: "ErrorDetail", fallback: "''", coalesce: true},
+	{name: "ErrorHint", fallback: "''", coalesce: true},
+	{name: "ErrorPosition", fallback: "0", coalesce: true},
+	{name: "ErrorFile", fallback: "''", coalesce: true},
+	{name: "ErrorLine", fallback: "0", coalesce: true},
+}
+
+// SelectColumns возвращает список столбцов для чтения строк таблицы миграций. exists сообщает,
+// есть ли столбец в таблице: до Init таблица может быть в виде, оставленном прошлой версией.
+func SelectColumns(exists func(column string) bool) string {
+	columns := make([]string, 0, len(migrationColumns))
+
+	for _, column := range migrationColumns {
+		switch {
+		case column.fallback != "" && !exists(column.name):
+			columns = append(columns, column.fallback)
+		case column.coalesce:
+			columns = append(columns, "COALESCE("+column.name+", "+column.fallback+")")
+		default:
+			columns = append(columns, column.name)
+		}
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// ConflictTarget возвращает уникальный ключ строки миграции для ON CONFLICT.
+func ConflictTarget(migration entity.Migration) string {
+	if migration.GetVersion() == RepeatableVersion {
+		return RepeatableKey
+	}
+
+	return VersionKey
+}
@@ -0,0 +1,675 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	_ "modernc.org/sqlite"
+)
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqlStorage struct {
+	path       string
+	name       string
+	conn       *sql.DB
+	tx         *sql.Tx
+	db         querier
+	table      string
+	history    string
+	versionKey string
+	lockTable  string
+	savepoint  int
+
+	// busyTimeout — busy_timeout соединения в миллисекундах до вызова SetTimeouts.
+	busyTimeout      int
+	statementTimeout time.Duration
+
+	// columns — список столбцов для чтения миграций, missing — таблицы миграций ещё нет.
+	columns string
+	missing bool
+}
+
+type rows struct {
+	*sql.Rows
+}
+
+func (r rows) Close() {
+	_ = r.Rows.Close()
+}
+
+// errorColumns добавляются в таблицы, созданные до появления подробностей ошибок.
+var errorColumns = [][2]string{
+	{"ErrorCode", "VARCHAR(5)"},
+	{"ErrorMessage", "TEXT"},
+	{"ErrorDetail", "TEXT"},
+	{"ErrorHint", "TEXT"},
+	{"ErrorPosition", "INTEGER"},
+	{"ErrorFile", "VARCHAR(255)"},
+	{"ErrorLine", "INTEGER"},
+}
+
+// lockColumns добавляются в таблицы блокировки, созданные до проверки зависших блокировок.
+var lockColumns = [][2]string{
+	{"Hostname", "VARCHAR(255)"},
+}
+
+func init() {
+	storage.Register("sqlite", New)
+	storage.Register("sqlite3", New)
+}
+
+// New создаёт хранилище SQLite. Строка подключения имеет вид sqlite://path/to/file.db,
+// sqlite:///abs/path.db или sqlite://:memory: для базы в памяти.
+func New(connString string, opts ...storage.Option) storage.Storage {
+	config := storage.NewConfig(opts...)
+
+	path := connString
+	if _, rest, ok := strings.Cut(connString, "://"); ok {
+		path = rest
+	}
+
+	// В SQLite схемы — это присоединённые базы, поэтому схема становится частью имени таблицы.
+	return &sqlStorage{
+		path:       path,
+		name:       config.QualifiedTable(),
+		table:      quoteIdentifier(config.QualifiedTable()),
+		history:    quoteIdentifier(config.QualifiedTable() + "_history"),
+		versionKey: quoteIdentifier(config.QualifiedTable() + "_version_key"),
+		lockTable:  quoteIdentifier(config.QualifiedTable() + "_lock"),
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (s *sqlStorage) Connect(ctx context.Context) error {
+	conn, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return err
+	}
+
+	// Одно соединение: база в памяти живёт, пока открыто соединение, а SQLite всё равно
+	// допускает только одного писателя.
+	conn.SetMaxOpenConns(1)
+
+	s.conn = conn
+	s.db = conn
+
+	// SQLite создаёт файл базы при первом запросе, поэтому до Init отсутствующий файл не открывается.
+	if s.fileMissing() {
+		s.missing = true
+		s.columns = storage.SelectColumns(func(string) bool { return false })
+		return nil
+	}
+
+	if err = s.readState(ctx); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) Init(ctx context.Context) error {
+	conn := s.conn
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			Version INTEGER NOT NULL,
+			Name VARCHAR(100),
+			Status VARCHAR(20),
+			StatusChangeTime TIMESTAMP,
+			AppliedTime TIMESTAMP,
+			Duration BIGINT,
+			Checksum VARCHAR(64),
+			ErrorCode VARCHAR(5),
+			ErrorMessage TEXT,
+			ErrorDetail TEXT,
+			ErrorHint TEXT,
+			ErrorPosition INTEGER,
+			ErrorFile VARCHAR(255),
+			ErrorLine INTEGER
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS %[2]s ON %[1]s %[6]s;
+		CREATE UNIQUE INDEX IF NOT EXISTS %[5]s ON %[1]s %[7]s;
+		CREATE TABLE IF NOT EXISTS %[3]s (
+			ID INTEGER PRIMARY KEY CHECK (ID = 1),
+			Pid INTEGER,
+			Hostname VARCHAR(255)
+		);
+		CREATE TABLE IF NOT EXISTS %[4]s (
+			ID INTEGER PRIMARY KEY AUTOINCREMENT,
+			Version INTEGER NOT NULL,
+			Name VARCHAR(100),
+			Status VARCHAR(20),
+			ChangeTime TIMESTAMP,
+			Duration BIGINT,
+			Hostname VARCHAR(255),
+			OsUser VARCHAR(255),
+			ToolVersion VARCHAR(100),
+			Error TEXT
+		);`, s.table, s.versionKey, s.lockTable, s.history, quoteIdentifier(s.name+"_repeatable_key"),
+		storage.VersionKey, storage.RepeatableKey)
+
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	if err := addColumns(ctx, conn, s.name, s.table, errorColumns); err != nil {
+		return err
+	}
+
+	if err := addColumns(ctx, conn, s.name+"_lock", s.lockTable, lockColumns); err != nil {
+		return err
+	}
+
+	if err := s.partialVersionKey(ctx, conn); err != nil {
+		return err
+	}
+
+	return s.readState(ctx)
+}
+
+// fileMissing сообщает, что файла базы ещё нет. Строки подключения с параметрами и база в памяти
+// открываются как есть.
+func (s *sqlStorage) fileMissing() bool {
+	if s.path == ":memory:" || strings.HasPrefix(s.path, "file:") || strings.Contains(s.path, "?") {
+		return false
+	}
+
+	_, err := os.Stat(s.path)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// readState читает busy_timeout соединения и запоминает, какие столбцы есть в таблице миграций,
+// чтобы до Init читать таблицу в виде, оставленном прошлой версией, или считать пустой, если её нет.
+func (s *sqlStorage) readState(ctx context.Context) error {
+	if err := s.conn.QueryRowContext(ctx, `PRAGMA busy_timeout;`).Scan(&s.busyTimeout); err != nil {
+		return err
+	}
+
+	rows, err := s.conn.QueryContext(ctx, `SELECT lower(name) FROM pragma_table_info(?);`, s.name)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return err
+		}
+
+		columns[column] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	s.missing = len(columns) == 0
+	s.columns = storage.SelectColumns(func(column string) bool { return columns[strings.ToLower(column)] })
+
+	return nil
+}
+
+// addColumns добавляет недостающие столбцы: в SQLite нет ADD COLUMN IF NOT EXISTS.
+func addColumns(ctx context.Context, conn *sql.DB, name, table string, columns [][2]string) error {
+	for _, column := range columns {
+		var count int
+
+		query := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;`
+		if err := conn.QueryRowContext(ctx, query, name, column[0]).Scan(&count); err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		query = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column[0], column[1])
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// partialVersionKey заменяет уникальный индекс версии в таблицах, созданных до повторяемых миграций,
+// на частичный: у всех повторяемых миграций версия RepeatableVersion.
+func (s *sqlStorage) partialVersionKey(ctx context.Context, conn *sql.DB) error {
+	var partial bool
+
+	query := `SELECT partial FROM pragma_index_list(?) WHERE name = ?;`
+	if err := conn.QueryRowContext(ctx, query, s.name, s.name+"_version_key").Scan(&partial); err != nil || partial {
+		return err
+	}
+
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		DROP INDEX %[2]s;
+		CREATE UNIQUE INDEX %[2]s ON %[1]s %[3]s;`, s.table, s.versionKey, storage.VersionKey))
+
+	return err
+}
+
+func (s *sqlStorage) Close(context.Context) error {
+	return s.conn.Close()
+}
+
+func (s *sqlStorage) DeleteMigrations(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s;", s.table))
+	return err
+}
+
+func (s *sqlStorage) SelectMigrations(ctx context.Context) ([]entity.Migration, error) {
+	migrations, err := s.selectMigrations(ctx, storage.VersionedCondition+" ORDER BY Version DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) == 0 {
+		return nil, storage.ErrMigrationNotFound
+	}
+
+	return migrations, nil
+}
+
+func (s *sqlStorage) SelectRepeatableMigrations(ctx context.Context) ([]entity.Migration, error) {
+	return s.selectMigrations(ctx, storage.RepeatableCondition+" ORDER BY Name")
+}
+
+func (s *sqlStorage) selectMigrations(ctx context.Context, condition string) (migrations []entity.Migration, err error) {
+	if s.missing {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s;`, s.columns, s.table, condition)
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		migration, err := scanMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, rows.Err()
+}
+
+func (s *sqlStorage) SelectLastMigrationByStatus(ctx context.Context, status string) (entity.Migration, error) {
+	switch status {
+	case storage.StatusSuccess:
+	case storage.StatusError:
+	case storage.StatusProcess:
+	case storage.StatusCancellation:
+	case storage.StatusCancel:
+	case storage.StatusBaseline:
+	default:
+		return nil, storage.ErrUnexpectedStatus
+	}
+
+	if s.missing {
+		return nil, storage.ErrMigrationNotFound
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE Status = ? AND %s ORDER BY Version DESC LIMIT 1;`,
+		s.columns, s.table, storage.VersionedCondition)
+
+	migration, err := scanMigration(s.db.QueryRowContext(ctx, query, status))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrMigrationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return migration, nil
+}
+
+func scanMigration(row entity.Row) (entity.Migration, error) {
+	var (
+		name             string
+		version          int64
+		status           string
+		statusChangeTime time.Time
+		appliedTime      sql.NullTime
+		duration         int64
+		checksum         string
+		failure          entity.Failure
+	)
+
+	err := row.Scan(&name, &status, &version, &statusChangeTime, &appliedTime, &duration, &checksum,
+		&failure.Code, &failure.Message, &failure.Detail, &failure.Hint, &failure.Position, &failure.File, &failure.Line)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := entity.NewMigration(name, status, version, statusChangeTime)
+	migration.SetDuration(time.Duration(duration) * time.Millisecond)
+	migration.SetChecksum(checksum)
+	migration.SetFailure(failure)
+
+	if appliedTime.Valid {
+		migration.SetAppliedTime(appliedTime.Time)
+	}
+
+	return migration, nil
+}
+
+func (s *sqlStorage) InsertMigration(ctx context.Context, migration entity.Migration) error {
+	var appliedTime interface{}
+	if !migration.GetAppliedTime().IsZero() {
+		appliedTime = migration.GetAppliedTime()
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (Version, Name, Status, StatusChangeTime, AppliedTime, Duration, Checksum,
+			ErrorCode, ErrorMessage, ErrorDetail, ErrorHint, ErrorPosition, ErrorFile, ErrorLine)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''),
+			NULLIF(?, 0), NULLIF(?, ''), NULLIF(?, 0))
+		ON CONFLICT %s DO UPDATE SET
+			Name = excluded.Name,
+			Status = excluded.Status,
+			StatusChangeTime = excluded.StatusChangeTime,
+			AppliedTime = excluded.AppliedTime,
+			Duration = excluded.Duration,
+			Checksum = excluded.Checksum,
+			ErrorCode = excluded.ErrorCode,
+			ErrorMessage = excluded.ErrorMessage,
+			ErrorDetail = excluded.ErrorDetail,
+			ErrorHint = excluded.ErrorHint,
+			ErrorPosition = excluded.ErrorPosition,
+			ErrorFile = excluded.ErrorFile,
+			ErrorLine = excluded.ErrorLine;`, s.table, storage.ConflictTarget(migration))
+
+	failure := migration.GetFailure()
+
+	_, err := s.db.ExecContext(ctx, query,
+		migration.GetVersion(), migration.GetName(), migration.GetStatus(), migration.GetStatusChangeTime(),
+		appliedTime, migration.GetDuration().Milliseconds(), migration.GetChecksum(),
+		failure.Code, failure.Message, failure.Detail, failure.Hint, failure.Position, failure.File, failure.Line)
+
+	return err
+}
+
+func (s *sqlStorage) UpdateChecksum(ctx context.Context, version int64, checksum string) error {
+	query := fmt.Sprintf(`UPDATE %s SET Checksum = ? WHERE Version = ?;`, s.table)
+
+	_, err := s.db.ExecContext(ctx, query, checksum, version)
+	return err
+}
+
+func (s *sqlStorage) UpdateVersion(ctx context.Context, from, to int64) error {
+	query := fmt.Sprintf(`UPDATE %s SET Version = ? WHERE Version = ?;`, s.table)
+
+	_, err := s.db.ExecContext(ctx, query, to, from)
+	return err
+}
+
+func (s *sqlStorage) InsertHistory(ctx context.Context, history entity.History) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (Version, Name, Status, ChangeTime, Duration, Hostname, OsUser, ToolVersion, Error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, s.history)
+
+	_, err := s.db.ExecContext(ctx, query, history.Version, history.Name, history.Status, history.Time,
+		history.Duration.Milliseconds(), history.Hostname, history.User, history.ToolVersion, history.Error)
+
+	return err
+}
+
+func (s *sqlStorage) SelectHistory(ctx context.Context, version int64) ([]entity.History, error) {
+	query := fmt.Sprintf(`
+		SELECT Version, Name, Status, ChangeTime, Duration, Hostname, OsUser, ToolVersion, Error
+		FROM %s WHERE ? = 0 OR Version = ? ORDER BY ID;`, s.history)
+
+	rows, err := s.db.QueryContext(ctx, query, version, version)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	history := make([]entity.History, 0)
+
+	for rows.Next() {
+		var (
+			entry    entity.History
+			duration int64
+		)
+
+		err = rows.Scan(&entry.Version, &entry.Name, &entry.Status, &entry.Time, &duration,
+			&entry.Hostname, &entry.User, &entry.ToolVersion, &entry.Error)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Duration = time.Duration(duration) * time.Millisecond
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+func (s *sqlStorage) Migrate(ctx context.Context, query string) error {
+	ctx, cancel := s.statementContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query)
+	return err
+}
+
+// SetTimeouts задаёт busy_timeout соединения, а время запроса ограничивает контекстом:
+// statement_timeout в SQLite нет.
+func (s *sqlStorage) SetTimeouts(ctx context.Context, timeouts storage.Timeouts) error {
+	busyTimeout := s.busyTimeout
+	if timeouts.Lock > 0 {
+		busyTimeout = int(timeouts.Lock.Milliseconds())
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`PRAGMA busy_timeout = %d;`, busyTimeout)); err != nil {
+		return err
+	}
+
+	s.statementTimeout = timeouts.Statement
+	return nil
+}
+
+func (s *sqlStorage) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.statementTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.statementTimeout)
+}
+
+// TryLock захватывает блокировку строкой в таблице <table>_lock. В отличие от advisory lock
+// в PostgreSQL она не снимается при обрыве соединения, поэтому блокировку процесса, который
+// уже завершился на этой же машине, TryLock считает зависшей и забирает себе.
+func (s *sqlStorage) TryLock(ctx context.Context) (bool, error) {
+	hostname, _ := os.Hostname()
+
+	query := fmt.Sprintf(`INSERT OR IGNORE INTO %s (ID, Pid, Hostname) VALUES (1, ?, ?);`, s.lockTable)
+
+	result, err := s.conn.ExecContext(ctx, query, os.Getpid(), hostname)
+	if err != nil {
+		return false, err
+	}
+
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 1 {
+		return inserted == 1, err
+	}
+
+	var (
+		pid    int
+		holder string
+	)
+
+	query = fmt.Sprintf(`SELECT Pid, COALESCE(Hostname, '') FROM %s WHERE ID = 1;`, s.lockTable)
+
+	err = s.conn.QueryRowContext(ctx, query).Scan(&pid, &holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// О процессе на другой машине ничего не известно, такую блокировку снимает только он сам.
+	// Строки без Hostname остались от версий до этой проверки и считаются локальными.
+	if (holder != "" && holder != hostname) || processAlive(pid) {
+		return false, nil
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET Pid = ?, Hostname = ? WHERE ID = 1 AND Pid = ? AND COALESCE(Hostname, '') = ?;`,
+		s.lockTable)
+
+	result, err = s.conn.ExecContext(ctx, query, os.Getpid(), hostname, pid, holder)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+func (s *sqlStorage) Unlock(ctx context.Context) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE ID = 1 AND Pid = ?;`, s.lockTable)
+
+	result, err := s.conn.ExecContext(ctx, query, os.Getpid())
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return storage.ErrLockNotHeld
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) LockHolder(ctx context.Context) (pid int, err error) {
+	query := fmt.Sprintf(`SELECT Pid FROM %s WHERE ID = 1;`, s.lockTable)
+
+	err = s.conn.QueryRowContext(ctx, query).Scan(&pid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrLockHolderUnknown
+	}
+
+	return pid, err
+}
+
+// Transaction открывает транзакцию, а внутри уже открытой транзакции использует SAVEPOINT.
+func (s *sqlStorage) Transaction(ctx context.Context, fn func(storage.Storage) error) (err error) {
+	if s.tx != nil {
+		return s.transactionSavepoint(ctx, fn)
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(s.withTx(tx)); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil && !errors.Is(errRollback, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback: %s)", err, errRollback)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) transactionSavepoint(ctx context.Context, fn func(storage.Storage) error) (err error) {
+	name := fmt.Sprintf("sp_%d", s.savepoint+1)
+
+	if _, err = s.tx.ExecContext(ctx, "SAVEPOINT "+name+";"); err != nil {
+		return err
+	}
+
+	nested := s.withTx(s.tx)
+	nested.savepoint = s.savepoint + 1
+
+	if err = fn(nested); err != nil {
+		query := "ROLLBACK TO SAVEPOINT " + name + "; RELEASE SAVEPOINT " + name + ";"
+		if _, errRollback := s.tx.ExecContext(ctx, query); errRollback != nil {
+			return fmt.Errorf("%w (rollback: %s)", err, errRollback)
+		}
+
+		return err
+	}
+
+	_, err = s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name+";")
+	return err
+}
+
+func (s *sqlStorage) withTx(tx *sql.Tx) *sqlStorage {
+	return &sqlStorage{
+		path:       s.path,
+		name:       s.name,
+		conn:       s.conn,
+		tx:         tx,
+		db:         tx,
+		table:      s.table,
+		history:    s.history,
+		versionKey: s.versionKey,
+		lockTable:  s.lockTable,
+		savepoint:  s.savepoint,
+
+		busyTimeout:      s.busyTimeout,
+		statementTimeout: s.statementTimeout,
+
+		columns: s.columns,
+		missing: s.missing,
+	}
+}
+
+func (s *sqlStorage) Exec(ctx context.Context, query string, args ...interface{}) error {
+	ctx, cancel := s.statementContext(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *sqlStorage) Query(ctx context.Context, query string, args ...interface{}) (entity.Rows, error) {
+	r, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows{r}, nil
+}
+
+func (s *sqlStorage) QueryRow(ctx context.Context, query string, args ...interface{}) entity.Row {
+	return s.db.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,415 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage"
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := storage.Open("sqlite://:memory:")
+	require.Nil(t, err)
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	_, err = s.SelectMigrations(ctx)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+
+	changeTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	first := entity.NewMigration("init", storage.StatusSuccess, 1, changeTime)
+	first.SetAppliedTime(changeTime)
+	first.SetDuration(1500 * time.Millisecond)
+	first.SetChecksum("abc")
+	require.Nil(t, s.InsertMigration(ctx, first))
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("add", storage.StatusCancel, 2, changeTime)))
+
+	migrations, err := s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, int64(2), migrations[0].GetVersion())
+	require.True(t, migrations[0].GetAppliedTime().IsZero())
+	require.True(t, changeTime.Equal(migrations[1].GetAppliedTime()))
+	require.Equal(t, 1500*time.Millisecond, migrations[1].GetDuration())
+
+	first.SetStatus(storage.StatusCancel)
+	first.SetAppliedTime(time.Time{})
+	require.Nil(t, s.InsertMigration(ctx, first))
+	require.Nil(t, s.UpdateChecksum(ctx, 1, "def"))
+
+	last, err := s.SelectLastMigrationByStatus(ctx, storage.StatusCancel)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), last.GetVersion())
+
+	_, err = s.SelectLastMigrationByStatus(ctx, storage.StatusSuccess)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+
+	migrations, err = s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, "def", migrations[1].GetChecksum())
+
+	quoted := entity.NewMigration("add_o'brien", storage.StatusSuccess, 2, changeTime)
+	require.Nil(t, s.InsertMigration(ctx, quoted))
+
+	migrations, err = s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, "add_o'brien", migrations[0].GetName())
+	require.Equal(t, storage.StatusSuccess, migrations[0].GetStatus())
+
+	require.Nil(t, s.DeleteMigrations(ctx))
+	_, err = s.SelectMigrations(ctx)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+}
+
+func TestConnectReadOnly(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "read.db")
+
+	missing := New("sqlite://" + file)
+	require.Nil(t, missing.Connect(ctx))
+
+	_, err := missing.SelectMigrations(ctx)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+	require.Nil(t, missing.Close(ctx))
+
+	_, err = os.Stat(file)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	db, err := sql.Open("sqlite", file)
+	require.Nil(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE schema_migrations (Version INTEGER, Name VARCHAR(100), Status VARCHAR(20), StatusChangeTime TIMESTAMP);
+		INSERT INTO schema_migrations VALUES (1, 'init', 'применена', '2022-01-01 00:00:00');`)
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+
+	legacy := New("sqlite://" + file)
+	require.Nil(t, legacy.Connect(ctx))
+	defer legacy.Close(ctx)
+
+	migrations, err := legacy.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+	require.Equal(t, "init", migrations[0].GetName())
+	require.Empty(t, migrations[0].GetChecksum())
+
+	var tables int
+	require.Nil(t, legacy.QueryRow(ctx, `SELECT COUNT(*) FROM sqlite_master;`).Scan(&tables))
+	require.Equal(t, 1, tables)
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	path := "sqlite://" + filepath.Join(t.TempDir(), "lock.db")
+
+	first := New(path)
+	require.Nil(t, first.Connect(ctx))
+	require.Nil(t, first.Init(ctx))
+	defer first.Close(ctx)
+
+	second := New(path)
+	require.Nil(t, second.Connect(ctx))
+	require.Nil(t, second.Init(ctx))
+	defer second.Close(ctx)
+
+	_, err := first.LockHolder(ctx)
+	require.ErrorIs(t, err, storage.ErrLockHolderUnknown)
+
+	locked, err := first.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, locked)
+
+	locked, err = second.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, locked)
+
+	pid, err := second.LockHolder(ctx)
+	require.Nil(t, err)
+	require.NotZero(t, pid)
+
+	require.Nil(t, first.Unlock(ctx))
+	require.ErrorIs(t, first.Unlock(ctx), storage.ErrLockNotHeld)
+
+	locked, err = second.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, locked)
+	require.Nil(t, second.Unlock(ctx))
+}
+
+func TestStaleLock(t *testing.T) {
+	ctx := context.Background()
+	path := "sqlite://" + filepath.Join(t.TempDir(), "lock.db")
+
+	// PID завершившегося процесса: его блокировка осталась бы после kill -9.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.Nil(t, cmd.Run())
+	dead := cmd.ProcessState.Pid()
+
+	hostname, err := os.Hostname()
+	require.Nil(t, err)
+
+	s := New(path)
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	require.Nil(t, s.Migrate(ctx, fmt.Sprintf(`INSERT INTO "schema_migrations_lock" VALUES (1, %d, 'other-host');`, dead)))
+
+	locked, err := s.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, locked)
+
+	require.Nil(t, s.Migrate(ctx, fmt.Sprintf(`UPDATE "schema_migrations_lock" SET Hostname = '%s';`, hostname)))
+
+	locked, err = s.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, locked)
+
+	pid, err := s.LockHolder(ctx)
+	require.Nil(t, err)
+	require.Equal(t, os.Getpid(), pid)
+	require.Nil(t, s.Unlock(ctx))
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	errExpected := errors.New("expected error")
+
+	s := New("sqlite://:memory:")
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	err := s.Transaction(ctx, func(tx storage.Storage) error {
+		require.Nil(t, tx.Migrate(ctx, "CREATE TABLE kept (id INTEGER); INSERT INTO kept VALUES (1);"))
+
+		err := tx.Transaction(ctx, func(nested storage.Storage) error {
+			require.Nil(t, nested.Migrate(ctx, "INSERT INTO kept VALUES (2);"))
+			return errExpected
+		})
+		require.ErrorIs(t, err, errExpected)
+
+		return nil
+	})
+	require.Nil(t, err)
+
+	var count int
+	require.Nil(t, s.QueryRow(ctx, "SELECT COUNT(*) FROM kept;").Scan(&count))
+	require.Equal(t, 1, count)
+
+	err = s.Transaction(ctx, func(tx storage.Storage) error {
+		require.Nil(t, tx.InsertMigration(ctx, entity.NewMigration("init", storage.StatusSuccess, 1, time.Now())))
+		require.Nil(t, tx.Migrate(ctx, "CREATE TABLE rollback_test (id INTEGER);"))
+
+		return errExpected
+	})
+	require.ErrorIs(t, err, errExpected)
+
+	_, err = s.SelectMigrations(ctx)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+
+	rows, err := s.Query(ctx, "SELECT name FROM sqlite_master WHERE name = 'rollback_test';")
+	require.Nil(t, err)
+	defer rows.Close()
+	require.False(t, rows.Next())
+}
+
+func TestSchema(t *testing.T) {
+	ctx := context.Background()
+	path := "sqlite://" + filepath.Join(t.TempDir(), "schema.db")
+
+	billing := New(path, storage.WithSchema("billing"))
+	require.Nil(t, billing.Connect(ctx))
+	require.Nil(t, billing.Init(ctx))
+	defer billing.Close(ctx)
+
+	users := New(path, storage.WithSchema(`us"ers`), storage.WithTable("migrations"))
+	require.Nil(t, users.Connect(ctx))
+	require.Nil(t, users.Init(ctx))
+	defer users.Close(ctx)
+
+	require.Nil(t, billing.InsertMigration(ctx, entity.NewMigration("init", storage.StatusSuccess, 1, time.Now())))
+
+	_, err := users.SelectMigrations(ctx)
+	require.ErrorIs(t, err, storage.ErrMigrationNotFound)
+
+	locked, err := billing.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, locked)
+
+	locked, err = users.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, locked)
+
+	require.Nil(t, billing.Unlock(ctx))
+	require.Nil(t, users.Unlock(ctx))
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	s := New("sqlite://:memory:")
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	changeTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	entries := []entity.History{
+		{Version: 1, Name: "init", Status: storage.StatusSuccess, Time: changeTime, Duration: time.Second, Hostname: "host"},
+		{Version: 2, Name: "add", Status: storage.StatusError, Time: changeTime, User: "deploy", Error: "syntax error"},
+		{Version: 1, Name: "init", Status: storage.StatusCancel, Time: changeTime, ToolVersion: "v1.0.0"},
+	}
+
+	for _, entry := range entries {
+		require.Nil(t, s.InsertHistory(ctx, entry))
+	}
+
+	history, err := s.SelectHistory(ctx, 0)
+	require.Nil(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, "syntax error", history[1].Error)
+	require.Equal(t, time.Second, history[0].Duration)
+	require.True(t, changeTime.Equal(history[2].Time))
+
+	history, err = s.SelectHistory(ctx, 1)
+	require.Nil(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, storage.StatusCancel, history[1].Status)
+
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("init", storage.StatusSuccess, 1, changeTime)))
+	require.Nil(t, s.UpdateVersion(ctx, 1, 20000101000001))
+
+	history, err = s.SelectHistory(ctx, 1)
+	require.Nil(t, err)
+	require.Len(t, history, 2)
+
+	history, err = s.SelectHistory(ctx, 20000101000001)
+	require.Nil(t, err)
+	require.Empty(t, history)
+
+	migrations, err := s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(20000101000001), migrations[0].GetVersion())
+}
+
+func TestFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "failure.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	require.Nil(t, err)
+	_, err = legacy.Exec(`CREATE TABLE schema_migrations (Version INTEGER NOT NULL, Name VARCHAR(100),
+		Status VARCHAR(20), StatusChangeTime TIMESTAMP, AppliedTime TIMESTAMP, Duration BIGINT, Checksum VARCHAR(64));`)
+	require.Nil(t, err)
+	require.Nil(t, legacy.Close())
+
+	s := New("sqlite://" + path)
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	failure := entity.Failure{
+		Code: "42601", Message: "syntax error", Hint: "check the query", Position: 12, File: "db/00001_init.sql", Line: 3,
+	}
+
+	failed := entity.NewMigration("init", storage.StatusError, 1, time.Now())
+	failed.SetFailure(failure)
+	require.Nil(t, s.InsertMigration(ctx, failed))
+
+	migrations, err := s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Equal(t, failure, migrations[0].GetFailure())
+
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("init", storage.StatusSuccess, 1, time.Now())))
+
+	migrations, err = s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Equal(t, entity.Failure{}, migrations[0].GetFailure())
+}
+
+func TestTimeouts(t *testing.T) {
+	ctx := context.Background()
+
+	s := New("sqlite://:memory:")
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	var busyTimeout int
+
+	require.Nil(t, s.SetTimeouts(ctx, storage.Timeouts{Statement: 50 * time.Millisecond, Lock: 3 * time.Second}))
+	require.Nil(t, s.QueryRow(ctx, `PRAGMA busy_timeout;`).Scan(&busyTimeout))
+	require.Equal(t, 3000, busyTimeout)
+
+	endless := `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c;`
+	require.NotNil(t, s.Exec(ctx, endless))
+
+	require.Nil(t, s.SetTimeouts(ctx, storage.Timeouts{}))
+	require.Nil(t, s.QueryRow(ctx, `PRAGMA busy_timeout;`).Scan(&busyTimeout))
+	require.Equal(t, 0, busyTimeout)
+}
+
+func TestRepeatable(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "repeatable.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	require.Nil(t, err)
+	_, err = legacy.Exec(`CREATE TABLE schema_migrations (Version INTEGER NOT NULL, Name VARCHAR(100),
+		Status VARCHAR(20), StatusChangeTime TIMESTAMP, AppliedTime TIMESTAMP, Duration BIGINT, Checksum VARCHAR(64));
+		CREATE UNIQUE INDEX schema_migrations_version_key ON schema_migrations (Version);`)
+	require.Nil(t, err)
+	require.Nil(t, legacy.Close())
+
+	s := New("sqlite://" + path)
+	require.Nil(t, s.Connect(ctx))
+	require.Nil(t, s.Init(ctx))
+	defer s.Close(ctx)
+
+	now := time.Now()
+
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("init", storage.StatusSuccess, 1, now)))
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("views", storage.StatusError, storage.RepeatableVersion, now)))
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("grants", storage.StatusSuccess, storage.RepeatableVersion, now)))
+
+	views := entity.NewMigration("views", storage.StatusSuccess, storage.RepeatableVersion, now)
+	views.SetChecksum("abc")
+	require.Nil(t, s.InsertMigration(ctx, views))
+
+	migrations, err := s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+	require.Equal(t, int64(1), migrations[0].GetVersion())
+
+	repeatable, err := s.SelectRepeatableMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, repeatable, 2)
+	require.Equal(t, "grants", repeatable[0].GetName())
+	require.Equal(t, "views", repeatable[1].GetName())
+	require.Equal(t, storage.StatusSuccess, repeatable[1].GetStatus())
+	require.Equal(t, "abc", repeatable[1].GetChecksum())
+
+	last, err := s.SelectLastMigrationByStatus(ctx, storage.StatusSuccess)
+	require.Nil(t, err)
+	require.Equal(t, "init", last.GetName())
+
+	require.Nil(t, s.InsertMigration(ctx, entity.NewMigration("init", storage.StatusCancel, 1, now)))
+	migrations, err = s.SelectMigrations(ctx)
+	require.Nil(t, err)
+	require.Len(t, migrations, 1)
+}
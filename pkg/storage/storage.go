@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MyLi2tlePony/sql-migrator/pkg/storage/entity"
+)
+
+type Storage interface {
+	entity.Executor
+
+	// SelectMigrations возвращает версионные миграции, без повторяемых.
+	SelectMigrations(context.Context) ([]entity.Migration, error)
+	// SelectRepeatableMigrations возвращает повторяемые миграции в порядке имён.
+	SelectRepeatableMigrations(context.Context) ([]entity.Migration, error)
+	SelectLastMigrationByStatus(context.Context, string) (entity.Migration, error)
+	// Connect подключается к базе, ничего в ней не меняя. Пока не вызван Init, таблицы может
+	// не быть, и чтение возвращает пустой результат.
+	Connect(context.Context) error
+	// Init создаёт служебные таблицы и обновляет таблицы, созданные прошлыми версиями.
+	Init(context.Context) error
+	Close(context.Context) error
+	// InsertMigration создаёт или обновляет строку миграции: версионной — по версии,
+	// повторяемой (с версией RepeatableVersion) — по имени.
+	InsertMigration(context.Context, entity.Migration) error
+	UpdateChecksum(ctx context.Context, version int64, checksum string) error
+	// UpdateVersion переносит запись миграции на новую версию. Журнал не меняется.
+	UpdateVersion(ctx context.Context, from, to int64) error
+	InsertHistory(context.Context, entity.History) error
+	// SelectHistory возвращает журнал в порядке записи, version 0 — по всем миграциям.
+	SelectHistory(ctx context.Context, version int64) ([]entity.History, error)
+	Migrate(context.Context, string) error
+	// SetTimeouts ограничивает время последующих запросов миграции и ожидания ими блокировок.
+	SetTimeouts(context.Context, Timeouts) error
+	DeleteMigrations(context.Context) error
+	TryLock(context.Context) (bool, error)
+	Unlock(context.Context) error
+	LockHolder(context.Context) (int, error)
+	Transaction(context.Context, func(Storage) error) error
+}
+
+// Config содержит общие для всех драйверов настройки хранилища.
+type Config struct {
+	Schema string
+	Table  string
+}
+
+type Option func(*Config)
+
+// Timeouts ограничивают выполнение миграции: Statement — время одного запроса, Lock — ожидание
+// блокировки. Нулевое значение оставляет ограничение, заданное в базе по умолчанию.
+type Timeouts struct {
+	Statement time.Duration
+	Lock      time.Duration
+}
+
+// Driver создаёт хранилище по строке подключения. Подключение к базе происходит в Connect.
+type Driver func(connString string, opts ...Option) Storage
+
+const DefaultTable = "schema_migrations"
+
+// DefaultDriver открывает строки подключения без схемы, например "host=db user=app dbname=x".
+const DefaultDriver = "postgres"
+
+// RepeatableVersion — версия строк повторяемых миграций в служебной таблице.
+const RepeatableVersion int64 = 0
+
+const (
+	StatusProcess      = "применение"
+	StatusSuccess      = "применена"
+	StatusError        = "ошибка"
+	StatusCancellation = "отмена"
+	StatusCancel       = "отменена"
+	StatusBaseline     = "базовая"
+
+	// StatusConverted встречается только в журнале: версию миграции перевели в метку времени.
+	StatusConverted = "перенумерована"
+)
+
+var (
+	ErrUnexpectedStatus  = errors.New("err unexpected status")
+	ErrMigrationNotFound = errors.New("migration not found")
+	ErrLockNotHeld       = errors.New("lock is not held")
+	ErrLockHolderUnknown = errors.New("lock holder unknown")
+	ErrUnknownDriver     = errors.New("unknown storage driver")
+
+	driversMu sync.Mutex
+	drivers   = make(map[string]Driver)
+)
+
+func WithTable(table string) Option {
+	return func(config *Config) {
+		if table != "" {
+			config.Table = table
+		}
+	}
+}
+
+// WithSchema задаёт схему служебной таблицы. Схема создаётся при подключении, если её нет.
+func WithSchema(schema string) Option {
+	return func(config *Config) {
+		config.Schema = schema
+	}
+}
+
+// QualifiedTable возвращает имя таблицы со схемой через точку, без экранирования.
+func (c Config) QualifiedTable() string {
+	if c.Schema == "" {
+		return c.Table
+	}
+
+	return c.Schema + "." + c.Table
+}
+
+// HistoryTable возвращает имя таблицы журнала статусов: <table>_history.
+func (c Config) HistoryTable() string {
+	return c.Table + "_history"
+}
+
+func NewConfig(opts ...Option) Config {
+	config := Config{
+		Table: DefaultTable,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return config
+}
+
+// Register делает драйвер доступным для строк подключения со схемой scheme, например "postgres".
+// Обычно вызывается из init() пакета драйвера.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil {
+		panic(fmt.Sprintf("storage: driver for %q is nil", scheme))
+	}
+
+	if _, ok := drivers[scheme]; ok {
+		panic(fmt.Sprintf("storage: driver for %q registered twice", scheme))
+	}
+
+	drivers[scheme] = driver
+}
+
+// Drivers возвращает отсортированный список зарегистрированных схем.
+func Drivers() []string {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open выбирает драйвер по схеме строки подключения. Строки без схемы, как DSN вида
+// "ключ=значение" из libpq, открывает DefaultDriver.
+func Open(connString string, opts ...Option) (Storage, error) {
+	scheme, _, ok := strings.Cut(connString, "://")
+	if !ok {
+		scheme = DefaultDriver
+	}
+
+	driversMu.Lock()
+	driver, ok := drivers[strings.ToLower(scheme)]
+	driversMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (registered: %s)", ErrUnknownDriver, scheme, strings.Join(Drivers(), ", "))
+	}
+
+	return driver(connString, opts...), nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	var opened string
+
+	Register("fake", func(connString string, opts ...Option) Storage {
+		opened = connString + "|" + NewConfig(opts...).Table
+		return nil
+	})
+
+	require.Panics(t, func() {
+		Register("fake", func(string, ...Option) Storage { return nil })
+	})
+	require.Contains(t, Drivers(), "fake")
+
+	_, err := Open("fake://db", WithTable("custom"))
+	require.Nil(t, err)
+	require.Equal(t, "fake://db|custom", opened)
+
+	_, err = Open("FAKE://db", WithTable(""))
+	require.Nil(t, err)
+	require.Equal(t, "FAKE://db|"+DefaultTable, opened)
+
+	_, err = Open("mysql://db")
+	require.ErrorIs(t, err, ErrUnknownDriver)
+
+	_, err = Open("host=localhost dbname=db")
+	require.ErrorIs(t, err, ErrUnknownDriver)
+
+	Register(DefaultDriver, func(connString string, opts ...Option) Storage {
+		opened = DefaultDriver + "|" + connString
+		return nil
+	})
+
+	_, err = Open("host=localhost dbname=db")
+	require.Nil(t, err)
+	require.Equal(t, DefaultDriver+"|host=localhost dbname=db", opened)
+}